@@ -0,0 +1,164 @@
+package atomiccommit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditKind is kind of AuditEvent.
+type AuditKind string
+
+const (
+	AuditCompletion AuditKind = "completion" // transaction completion began
+	AuditVote       AuditKind = "vote"       // a participant voted
+	AuditOutcome    AuditKind = "outcome"    // transaction completed
+)
+
+// AuditEvent is structured record about a transaction for audit trail.
+type AuditEvent struct {
+	Kind         AuditKind      `json:"kind"`
+	Time         time.Time      `json:"time"`
+	Txn          string         `json:"txn"`
+	User         string         `json:"user,omitempty"`
+	Description  string         `json:"description,omitempty"`
+	Extension    map[string]any `json:"extension,omitempty"`
+	Status       Status         `json:"status"`                 // transaction status when the event happened
+	Elapsed      time.Duration  `json:"elapsed"`                // time since the transaction was created
+	Participant  string         `json:"participant,omitempty"`  // AuditVote: who voted
+	Err          string         `json:"err,omitempty"`          // AuditVote: why the vote failed
+	Participants []string       `json:"participants,omitempty"` // AuditCompletion, AuditOutcome
+}
+
+// AuditSink receives audit trail of transactions created with WithAudit.
+type AuditSink interface {
+	Record(ev AuditEvent)
+}
+
+// auditor is Synchoronizer that records transaction boundary events to sink.
+type auditor struct {
+	txn  *transaction
+	sink AuditSink
+}
+
+// event returns new audit event of kind about a.txn.
+func (a *auditor) event(kind AuditKind) AuditEvent {
+	txn := a.txn
+	now := time.Now()
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	return AuditEvent{
+		Kind:        kind,
+		Time:        now,
+		Txn:         txn.id,
+		User:        txn.user,
+		Description: txn.description,
+		Extension:   txn.extension,
+		Status:      txn.status,
+		Elapsed:     now.Sub(txn.created),
+	}
+}
+
+// participants returns names of data managers joined to a.txn.
+func (a *auditor) participants() []string {
+	a.txn.mu.Lock()
+	datav := a.txn.datav
+	a.txn.mu.Unlock()
+
+	namev := make([]string, 0, len(datav))
+	for _, dm := range datav {
+		namev = append(namev, participantName(dm))
+	}
+	return namev
+}
+
+// participantName returns name under which dm appears in audit trail.
+func participantName(dm DataManager) string {
+	if named, ok := dm.(NamedDataManager); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", dm)
+}
+
+// BeforeCompletion implements Synchoronizer.
+func (a *auditor) BeforeCompletion(txn Transaction) {
+	ev := a.event(AuditCompletion)
+	ev.Participants = a.participants()
+	a.sink.Record(ev)
+}
+
+// AfterCompletion implements Synchoronizer.
+func (a *auditor) AfterCompletion(txn Transaction) {
+	ev := a.event(AuditOutcome)
+	ev.Participants = a.participants()
+	a.sink.Record(ev)
+}
+
+// vote records vote of dm.
+func (a *auditor) vote(dm DataManager, err error) {
+	ev := a.event(AuditVote)
+	ev.Participant = participantName(dm)
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	a.sink.Record(ev)
+}
+
+// auditVote records vote of dm to audit trail of txn, if it has one.
+func (txn *transaction) auditVote(dm DataManager, err error) {
+	if txn.audit != nil {
+		txn.audit.vote(dm, err)
+	}
+}
+
+// JSONAuditSink is AuditSink that writes events as lines of JSON.
+type JSONAuditSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewJSONAuditSink creates new JSONAuditSink writing to w.
+func NewJSONAuditSink(w io.Writer) *JSONAuditSink {
+	return &JSONAuditSink{w: w}
+}
+
+// Record implements AuditSink.
+func (s *JSONAuditSink) Record(ev AuditEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		// only extension values can be not marshallable; such values
+		// are recorded as text
+		ext := make(map[string]any, len(ev.Extension))
+		for k, v := range ev.Extension {
+			if _, err := json.Marshal(v); err != nil {
+				v = fmt.Sprint(v)
+			}
+			ext[k] = v
+		}
+		ev.Extension = ext
+		data, err = json.Marshal(ev)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	if err != nil {
+		// the event is lost; it must not crash the committing goroutine
+		s.err = fmt.Errorf("audit: %s: %w", ev.Txn, err)
+		return
+	}
+	_, s.err = s.w.Write(append(data, '\n'))
+}
+
+// Err returns first error that happened when encoding or writing events.
+func (s *JSONAuditSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
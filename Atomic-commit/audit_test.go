@@ -0,0 +1,164 @@
+package atomiccommit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// auditLog is AuditSink that keeps all recorded events.
+type auditLog struct {
+	evv []AuditEvent
+}
+
+func (l *auditLog) Record(ev AuditEvent) { l.evv = append(l.evv, ev) }
+
+func TestMetadata(t *testing.T) {
+	txn, _ := New(context.Background(),
+		WithUser("alice"), WithDescription("transfer"),
+		WithExtension("ticket", 42), WithExtension("tags", []string{"urgent"}))
+
+	ext := map[string]any{"ticket": 42, "tags": []string{"urgent"}}
+	if txn.User() != "alice" || txn.Description() != "transfer" || !reflect.DeepEqual(txn.Extension(), ext) {
+		t.Fatalf("metadata: user=%q description=%q extension=%v",
+			txn.User(), txn.Description(), txn.Extension())
+	}
+	txn.Abort()
+}
+
+func TestAudit(t *testing.T) {
+	var log []string
+	audit := &auditLog{}
+	errVote := errors.New("vote failed")
+
+	ok, err := With(context.Background(), func(ctx context.Context) error {
+		txn := Current(ctx)
+		txn.Join(&dmRecord{name: "a", log: &log})
+		txn.Join(&dmRecord{name: "b", log: &log, voteErr: errVote})
+		return nil
+	}, WithUser("alice"), WithDescription("transfer"), WithExtension("ticket", 42), WithAudit(audit))
+	if ok || err != errVote {
+		t.Fatalf("with: ok=%v err=%v", ok, err)
+	}
+
+	type event struct {
+		Kind         AuditKind
+		Status       Status
+		Participant  string
+		Err          string
+		Participants []string
+	}
+	var have []event
+	for _, ev := range audit.evv {
+		if ev.User != "alice" || ev.Description != "transfer" || ev.Extension["ticket"] != 42 || ev.Txn == "" {
+			t.Errorf("event %v: metadata missing: %+v", ev.Kind, ev)
+		}
+		have = append(have, event{ev.Kind, ev.Status, ev.Participant, ev.Err, ev.Participants})
+	}
+	want := []event{
+		{AuditCompletion, Commiting, "", "", []string{"a", "b"}},
+		{AuditVote, Commiting, "a", "", nil},
+		{AuditVote, Commiting, "b", "vote failed", nil},
+		{AuditOutcome, Aborted, "", "", []string{"a", "b"}},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("audit:\nhave: %+v\nwant: %+v", have, want)
+	}
+}
+
+// dmCommitFail is dmRecord whose Commit fails.
+type dmCommitFail struct {
+	dmRecord
+	err error
+}
+
+func (d *dmCommitFail) Commit(ctx context.Context, txn Transaction) error {
+	d.dmRecord.Commit(ctx, txn)
+	return d.err
+}
+
+func TestAuditCommitFail(t *testing.T) {
+	var log []string
+	audit := &auditLog{}
+	errCommit := errors.New("commit failed")
+
+	txn, ctx := New(context.Background(), WithAudit(audit))
+	txn.Join(&dmRecord{name: "a", log: &log})
+	txn.Join(&dmCommitFail{dmRecord{name: "b", log: &log}, errCommit})
+	if err := txn.Commit(ctx); err != errCommit {
+		t.Fatalf("commit: err=%v", err)
+	}
+
+	var votes []string
+	for _, ev := range audit.evv {
+		if ev.Kind == AuditVote {
+			votes = append(votes, ev.Participant+": "+ev.Err)
+		}
+	}
+	if want := []string{"b: commit failed"}; !reflect.DeepEqual(votes, want) {
+		t.Fatalf("votes: %q;  want %q", votes, want)
+	}
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf)
+
+	txn, ctx := New(context.Background(), WithUser("bob"),
+		WithExtension("ticket", 42), WithExtension("done", make(chan struct{})), WithAudit(sink))
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []AuditKind
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var ev map[string]any
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		// values that are not marshallable are recorded as text
+		ext, _ := ev["extension"].(map[string]any)
+		if ev["user"] != "bob" || ev["txn"] != txn.ID() || ext["ticket"] != 42.0 || ext["done"] == nil {
+			t.Errorf("event: %v", ev)
+		}
+		kinds = append(kinds, AuditKind(ev["kind"].(string)))
+		if ev["kind"] == string(AuditOutcome) && ev["status"] != "commited" {
+			t.Errorf("outcome: %v", ev)
+		}
+	}
+	if want := []AuditKind{AuditCompletion, AuditOutcome}; !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("kinds: %v;  want %v", kinds, want)
+	}
+}
+
+// flakyJSON fails to marshal on every other call.
+type flakyJSON struct{ n *int }
+
+func (f flakyJSON) MarshalJSON() ([]byte, error) {
+	*f.n++
+	if *f.n%2 == 1 {
+		return nil, errors.New("flaky")
+	}
+	return []byte(`"ok"`), nil
+}
+
+func TestJSONAuditSinkMarshalError(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf)
+
+	var n int
+	txn, ctx := New(context.Background(), WithExtension("flaky", flakyJSON{&n}), WithAudit(sink))
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Err(); err == nil {
+		t.Fatal("event that could not be encoded was not reported")
+	}
+}
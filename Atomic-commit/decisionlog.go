@@ -0,0 +1,266 @@
+package atomiccommit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Kinds of records written to a DecisionLog.
+const (
+	DecisionPrepare = "prepare" // participants are about to vote
+	DecisionCommit  = "commit"  // all participants voted ok; transaction is committed
+	DecisionAbort   = "abort"   // transaction is aborted
+	DecisionEnd     = "end"     // second phase finished on all participants
+)
+
+// DecisionRecord is one entry of coordinator decision log.
+type DecisionRecord struct {
+	Txn          string   `json:"txn"`
+	Kind         string   `json:"kind"`
+	Participants []string `json:"participants,omitempty"`
+}
+
+// DecisionLog is write-ahead log where transaction coordinator persists its
+// decisions before running the second phase of two-phase commit.
+type DecisionLog interface {
+	// Append durably appends rec to the log.
+	Append(rec DecisionRecord) error
+
+	// Records returns all records in the log, oldest first.
+	Records() ([]DecisionRecord, error)
+}
+
+// NamedDataManager is a DataManager that can be found again after restart.
+//
+// When a DecisionLog is used, all participants of a transaction must be
+// NamedDataManagers. During recovery TPCFinish or TPCAbort can be called for
+// a transaction the data manager already completed, or never prepared; such
+// calls must be no-op.
+type NamedDataManager interface {
+	DataManager
+
+	// Name returns name that identifies the data manager across restarts.
+	Name() string
+}
+
+type decisionLogKey struct{}
+
+// WithDecisionLog returns context in which new transactions log commit
+// decisions to dlog.
+func WithDecisionLog(ctx context.Context, dlog DecisionLog) context.Context {
+	return context.WithValue(ctx, decisionLogKey{}, dlog)
+}
+
+// getDecisionLog returns decision log associated with provided context.
+// nil is returned if there is no association.
+func getDecisionLog(ctx context.Context) DecisionLog {
+	dlog, _ := ctx.Value(decisionLogKey{}).(DecisionLog)
+	return dlog
+}
+
+// logDecision appends record of kind about txn to its decision log.
+//
+// If participants is not nil, their names are included into the record.
+// It is no-op if txn has no decision log.
+func (txn *transaction) logDecision(kind string, participants []DataManager) error {
+	if txn.dlog == nil {
+		return nil
+	}
+
+	rec := DecisionRecord{Txn: txn.id, Kind: kind}
+	for _, dm := range participants {
+		named, ok := dm.(NamedDataManager)
+		if !ok {
+			return fmt.Errorf("transaction: %T participates in logged transaction, but is not a NamedDataManager", dm)
+		}
+		rec.Participants = append(rec.Participants, named.Name())
+	}
+
+	err := txn.dlog.Append(rec)
+	if err != nil {
+		return fmt.Errorf("transaction: decision log: %w", err)
+	}
+	return nil
+}
+
+// Recover completes every transaction left in doubt in dlog.
+//
+// For every transaction that has no end record, TPCFinish is re-driven on
+// all its participants if commit decision was logged, and TPCAbort
+// otherwise. The transaction stays in doubt, and is re-driven again by the
+// next Recover, if any of its participants could not be resolved or failed
+// to finish. resolve maps participant name, as returned by
+// NamedDataManager.Name, back to the data manager.
+func Recover(ctx context.Context, dlog DecisionLog, resolve func(name string) (DataManager, error)) error {
+	recv, err := dlog.Records()
+	if err != nil {
+		return fmt.Errorf("transaction: recover: %w", err)
+	}
+
+	// collect in-doubt transactions in log order
+	type inDoubt struct {
+		participants []string
+		commit       bool
+	}
+	var order []string
+	pending := map[string]*inDoubt{}
+	for _, rec := range recv {
+		switch rec.Kind {
+		case DecisionPrepare:
+			if _, ok := pending[rec.Txn]; !ok {
+				order = append(order, rec.Txn)
+			}
+			pending[rec.Txn] = &inDoubt{participants: rec.Participants}
+		case DecisionCommit, DecisionAbort:
+			t, ok := pending[rec.Txn]
+			if !ok {
+				return fmt.Errorf("transaction: recover: %s record for unknown transaction %s", rec.Kind, rec.Txn)
+			}
+			t.commit = (rec.Kind == DecisionCommit)
+		case DecisionEnd:
+			delete(pending, rec.Txn)
+		default:
+			return fmt.Errorf("transaction: recover: invalid record kind %q", rec.Kind)
+		}
+	}
+
+	var errv []error
+	for _, id := range order {
+		t, ok := pending[id]
+		if !ok {
+			continue
+		}
+
+		txn := &transaction{id: id, status: Aborting}
+		if t.commit {
+			txn.status = Commiting
+		}
+
+		// the transaction is left in doubt, to be completed by next
+		// recovery, unless all its participants were found and completed
+		done := true
+		for _, name := range t.participants {
+			dm, err := resolve(name)
+			if err != nil {
+				errv = append(errv, fmt.Errorf("transaction: recover %s: %s: %w", id, name, err))
+				done = false
+				continue
+			}
+			if t.commit {
+				err := dm.TPCFinish(ctx, txn)
+				if err != nil {
+					errv = append(errv, fmt.Errorf("transaction: recover %s: finish: %w", id, err))
+					done = false
+				}
+			} else {
+				dm.TPCAbort(ctx, txn)
+			}
+		}
+		if !done {
+			continue
+		}
+
+		err := dlog.Append(DecisionRecord{Txn: id, Kind: DecisionEnd})
+		if err != nil {
+			errv = append(errv, fmt.Errorf("transaction: recover %s: %w", id, err))
+		}
+	}
+
+	return errors.Join(errv...)
+}
+
+// FileDecisionLog is DecisionLog kept in a local file.
+//
+// Every record is stored as one line of JSON and is synced to disk before
+// Append returns.
+type FileDecisionLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenDecisionLog opens decision log at path, creating it if needed.
+//
+// A torn last record, left by crash in the middle of Append, is discarded.
+func OpenDecisionLog(path string) (*FileDecisionLog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if end := bytes.LastIndexByte(data, '\n') + 1; end != len(data) {
+		err = f.Truncate(int64(end))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &FileDecisionLog{f: f}, nil
+}
+
+// Append implements DecisionLog.
+func (l *FileDecisionLog) Append(rec DecisionRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.f.Write(data)
+	if err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Records implements DecisionLog.
+func (l *FileDecisionLog) Records() ([]DecisionRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recv []DecisionRecord
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without trailing newline was not completely written
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var rec DecisionRecord
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid record: %w", l.f.Name(), err)
+		}
+		recv = append(recv, rec)
+	}
+	return recv, nil
+}
+
+// Close closes the log file.
+func (l *FileDecisionLog) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,213 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func (d *dmRecord) Name() string { return d.name }
+
+func openTestDecisionLog(t *testing.T) (*FileDecisionLog, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "decision.log")
+	dlog, err := OpenDecisionLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dlog.Close() })
+	return dlog, path
+}
+
+func TestDecisionLogCommit(t *testing.T) {
+	dlog, _ := openTestDecisionLog(t)
+
+	var log []string
+	txn, ctx := New(WithDecisionLog(context.Background(), dlog))
+	txn.Join(&dmRecord{name: "a", log: &log})
+	txn.Join(&dmRecord{name: "b", log: &log})
+
+	err := txn.Commit(ctx)
+	if err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+
+	recv, err := dlog.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DecisionRecord{
+		{Txn: txn.ID(), Kind: DecisionPrepare, Participants: []string{"a", "b"}},
+		{Txn: txn.ID(), Kind: DecisionCommit},
+		{Txn: txn.ID(), Kind: DecisionEnd},
+	}
+	if !reflect.DeepEqual(recv, want) {
+		t.Fatalf("records:\nhave: %v\nwant: %v", recv, want)
+	}
+}
+
+func TestDecisionLogVoteFail(t *testing.T) {
+	dlog, _ := openTestDecisionLog(t)
+
+	var log []string
+	txn, ctx := New(WithDecisionLog(context.Background(), dlog))
+	txn.Join(&dmRecord{name: "a", log: &log, voteErr: errors.New("vote failed")})
+
+	err := txn.Commit(ctx)
+	if err == nil {
+		t.Fatal("commit: no error")
+	}
+
+	recv, err := dlog.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DecisionRecord{
+		{Txn: txn.ID(), Kind: DecisionPrepare, Participants: []string{"a"}},
+		{Txn: txn.ID(), Kind: DecisionAbort},
+		{Txn: txn.ID(), Kind: DecisionEnd},
+	}
+	if !reflect.DeepEqual(recv, want) {
+		t.Fatalf("records:\nhave: %v\nwant: %v", recv, want)
+	}
+}
+
+func TestDecisionLogUnnamed(t *testing.T) {
+	dlog, _ := openTestDecisionLog(t)
+
+	txn, ctx := New(WithDecisionLog(context.Background(), dlog))
+	dm := &dmAbortOnly{t: t, txn: txn}
+	dm.Modify()
+
+	err := txn.Commit(ctx)
+	if err == nil {
+		t.Fatal("commit with unnamed participant: no error")
+	}
+	if !(dm.nabort == 1 && txn.Status() == Aborted) {
+		t.Fatalf("commit: nabort=%d; txn.Status=%v", dm.nabort, txn.Status())
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dlog, path := openTestDecisionLog(t)
+
+	// "committed" was decided but not finished, "voting" crashed before
+	// decision, and "done" was completed.
+	for _, rec := range []DecisionRecord{
+		{Txn: "committed", Kind: DecisionPrepare, Participants: []string{"a", "b"}},
+		{Txn: "voting", Kind: DecisionPrepare, Participants: []string{"b"}},
+		{Txn: "done", Kind: DecisionPrepare, Participants: []string{"a"}},
+		{Txn: "committed", Kind: DecisionCommit},
+		{Txn: "done", Kind: DecisionCommit},
+		{Txn: "done", Kind: DecisionEnd},
+	} {
+		err := dlog.Append(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dlog.Close()
+
+	// simulate crash in the middle of the next record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString(`{"txn":"torn","ki`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dlog, err = OpenDecisionLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dlog.Close()
+
+	var log []string
+	dmv := map[string]DataManager{
+		"a": &dmRecord{name: "a", log: &log},
+		"b": &dmRecord{name: "b", log: &log},
+	}
+	resolve := func(name string) (DataManager, error) {
+		dm, ok := dmv[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return dm, nil
+	}
+
+	err = Recover(context.Background(), dlog, resolve)
+	if err != nil {
+		t.Fatalf("recover: %s", err)
+	}
+	want := []string{"a.finish", "b.finish", "b.tpcabort"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("recover:\nhave: %q\nwant: %q", log, want)
+	}
+
+	// second recovery has nothing to do
+	log = nil
+	err = Recover(context.Background(), dlog, resolve)
+	if err != nil {
+		t.Fatalf("recover 2: %s", err)
+	}
+	if len(log) != 0 {
+		t.Fatalf("recover 2: %q", log)
+	}
+}
+
+func TestRecoverFinishFails(t *testing.T) {
+	dlog, _ := openTestDecisionLog(t)
+	for _, rec := range []DecisionRecord{
+		{Txn: "committed", Kind: DecisionPrepare, Participants: []string{"a", "b"}},
+		{Txn: "committed", Kind: DecisionCommit},
+	} {
+		err := dlog.Append(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var log []string
+	errFinish := errors.New("finish failed")
+	a := &dmRecord{name: "a", log: &log}
+	b := &dmRecord{name: "b", log: &log, finishErr: errFinish}
+	resolve := func(name string) (DataManager, error) {
+		if name == "a" {
+			return a, nil
+		}
+		return b, nil
+	}
+
+	err := Recover(context.Background(), dlog, resolve)
+	if !errors.Is(err, errFinish) {
+		t.Fatalf("recover: err = %v; want %v", err, errFinish)
+	}
+
+	// b recovers from failure; the transaction is finished again
+	log = nil
+	b.finishErr = nil
+	err = Recover(context.Background(), dlog, resolve)
+	if err != nil {
+		t.Fatalf("recover 2: %s", err)
+	}
+	want := []string{"a.finish", "b.finish"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("recover 2:\nhave: %q\nwant: %q", log, want)
+	}
+
+	// now it is done
+	log = nil
+	err = Recover(context.Background(), dlog, resolve)
+	if err != nil {
+		t.Fatalf("recover 3: %s", err)
+	}
+	if len(log) != 0 {
+		t.Fatalf("recover 3: %q", log)
+	}
+}
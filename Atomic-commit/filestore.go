@@ -0,0 +1,403 @@
+package atomiccommit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// journalSuffix is suffix of FileManager journal files.
+const journalSuffix = ".journal"
+
+// fileJournalRecord is one entry of FileManager journal.
+//
+// A transaction journal lists staging files the transaction created, and
+// finally notes that the transaction was prepared.
+type fileJournalRecord struct {
+	Tmp      string `json:"tmp,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Prepared bool   `json:"prepared,omitempty"`
+}
+
+// fileTxn is state of a transaction in FileManager.
+type fileTxn struct {
+	id       string
+	staged   map[string]string // final path -> staging file
+	order    []string          // final paths in staging order
+	journal  *os.File
+	prepared bool
+}
+
+// FileManager is DataManager that updates several files atomically.
+//
+// Files written in a transaction are staged into temporary files next to
+// their final location. The staging files are synced to disk in TPCVote and
+// renamed into place in TPCFinish; abort removes them. Every transaction
+// keeps a small journal of its staging files in journal directory, so that
+// staging files left by a crash are removed when FileManager is opened again.
+// Transactions that were already prepared are kept in doubt to be finished or
+// aborted by Recover.
+type FileManager struct {
+	name       string
+	journalDir string
+
+	mu   sync.Mutex
+	txns map[string]*fileTxn // id -> transaction
+}
+
+// OpenFileManager opens FileManager keeping its journals in journalDir.
+//
+// name is returned by Name, so that FileManager could be used with DecisionLog.
+func OpenFileManager(name, journalDir string) (*FileManager, error) {
+	err := os.MkdirAll(journalDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &FileManager{
+		name:       name,
+		journalDir: journalDir,
+		txns:       make(map[string]*fileTxn),
+	}
+
+	err = m.cleanup()
+	if err != nil {
+		return nil, fmt.Errorf("filemanager: open %s: %w", journalDir, err)
+	}
+	return m, nil
+}
+
+// cleanup removes staging files of transactions left unprepared by a crash,
+// and loads prepared transactions in doubt.
+func (m *FileManager) cleanup() error {
+	entryv, err := os.ReadDir(m.journalDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entryv {
+		name := entry.Name()
+		if !strings.HasSuffix(name, journalSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(name, journalSuffix)
+
+		t, err := m.readJournal(id)
+		if err != nil {
+			return err
+		}
+		if t.prepared {
+			m.txns[id] = t
+			continue
+		}
+		err = m.discard(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readJournal loads transaction state from its journal.
+func (m *FileManager) readJournal(id string) (*fileTxn, error) {
+	f, err := os.Open(m.journalPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &fileTxn{id: id, staged: make(map[string]string)}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a line without trailing newline was not completely written
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var rec fileJournalRecord
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid record: %w", f.Name(), err)
+		}
+		if rec.Prepared {
+			t.prepared = true
+			continue
+		}
+		t.stage(rec.Path, rec.Tmp)
+	}
+	return t, nil
+}
+
+func (m *FileManager) journalPath(id string) string {
+	return filepath.Join(m.journalDir, id+journalSuffix)
+}
+
+// stage notes that path is staged into tmp.
+func (t *fileTxn) stage(path, tmp string) {
+	if _, ok := t.staged[path]; !ok {
+		t.order = append(t.order, path)
+	}
+	t.staged[path] = tmp
+}
+
+// log durably appends rec to journal of t.
+func (t *fileTxn) log(rec fileJournalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = t.journal.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	return t.journal.Sync()
+}
+
+// discard removes staging files and journal of t.
+func (m *FileManager) discard(t *fileTxn) error {
+	var errv []error
+	for _, path := range t.order {
+		err := os.Remove(t.staged[path])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errv = append(errv, err)
+		}
+	}
+	if t.journal != nil {
+		t.journal.Close()
+	}
+	if len(errv) == 0 {
+		err := os.Remove(m.journalPath(t.id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errv = append(errv, err)
+		}
+	}
+	return errors.Join(errv...)
+}
+
+// Name implements NamedDataManager.
+func (m *FileManager) Name() string { return m.name }
+
+// InDoubt returns ids of transactions that were prepared before restart and
+// are waiting to be finished or aborted.
+func (m *FileManager) InDoubt() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var idv []string
+	for id, t := range m.txns {
+		if t.prepared && t.journal == nil {
+			idv = append(idv, id)
+		}
+	}
+	sort.Strings(idv)
+	return idv
+}
+
+// WriteFile stages writing data to file at path in current transaction in ctx.
+//
+// The file is created with perm if it does not exist.
+func (m *FileManager) WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	txn := Current(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.txns[txn.ID()]
+	if !ok {
+		journal, err := os.OpenFile(m.journalPath(txn.ID()), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err == nil {
+			err = syncFile(m.journalDir)
+		}
+		if err != nil {
+			return fmt.Errorf("filemanager: %w", err)
+		}
+		txn.Join(m)
+		t = &fileTxn{id: txn.ID(), staged: make(map[string]string), journal: journal}
+		m.txns[txn.ID()] = t
+	}
+	if t.prepared {
+		panic("filemanager: write after transaction commit began")
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("filemanager: %w", err)
+	}
+	tmp, ok := t.staged[path]
+	if !ok {
+		dir, base := filepath.Split(path)
+		tmp = filepath.Join(dir, "."+base+".txn-"+txn.ID())
+
+		// journal the staging file before creating it, so that it is
+		// always found by cleanup.
+		err = t.log(fileJournalRecord{Tmp: tmp, Path: path})
+		if err != nil {
+			return fmt.Errorf("filemanager: %w", err)
+		}
+		t.stage(path, tmp)
+	}
+
+	err = os.WriteFile(tmp, data, perm)
+	if err != nil {
+		return fmt.Errorf("filemanager: %w", err)
+	}
+	return nil
+}
+
+// ReadFile returns content of file at path as seen by current transaction in ctx.
+func (m *FileManager) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	txn := Current(ctx)
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if t, ok := m.txns[txn.ID()]; ok {
+		if tmp, ok := t.staged[path]; ok {
+			path = tmp
+		}
+	}
+	m.mu.Unlock()
+
+	return os.ReadFile(path)
+}
+
+// abort discards transaction txn.
+func (m *FileManager) abort(txn Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.txns[txn.ID()]
+	if !ok {
+		return nil
+	}
+	delete(m.txns, txn.ID())
+	return m.discard(t)
+}
+
+// Abort implements DataManager.
+func (m *FileManager) Abort(txn Transaction) {
+	// staging files that cannot be removed are removed on next open
+	_ = m.abort(txn)
+}
+
+// TPCBegin implements DataManager.
+func (m *FileManager) TPCBegin(txn Transaction) {}
+
+// Commit implements DataManager.
+//
+// Files are already staged by WriteFile.
+func (m *FileManager) Commit(ctx context.Context, txn Transaction) error {
+	return nil
+}
+
+// TPCVote implements DataManager.
+//
+// It syncs staging files to disk and journals that txn is prepared.
+func (m *FileManager) TPCVote(ctx context.Context, txn Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.txns[txn.ID()]
+	if !ok {
+		return fmt.Errorf("filemanager: vote: unknown transaction %s", txn.ID())
+	}
+
+	dirs := make(map[string]bool)
+	for _, path := range t.order {
+		err := syncFile(t.staged[path])
+		if err != nil {
+			return fmt.Errorf("filemanager: vote: %w", err)
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		err := syncFile(dir)
+		if err != nil {
+			return fmt.Errorf("filemanager: vote: %w", err)
+		}
+	}
+
+	err := t.log(fileJournalRecord{Prepared: true})
+	if err != nil {
+		return fmt.Errorf("filemanager: vote: %w", err)
+	}
+	t.prepared = true
+	return nil
+}
+
+// TPCFinish implements DataManager.
+//
+// It renames staging files into place.
+func (m *FileManager) TPCFinish(ctx context.Context, txn Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.txns[txn.ID()]
+	if !ok {
+		// already finished
+		return nil
+	}
+	if !t.prepared {
+		return fmt.Errorf("filemanager: finish: transaction %s did not vote", txn.ID())
+	}
+
+	dirs := make(map[string]bool)
+	for _, path := range t.order {
+		err := os.Rename(t.staged[path], path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			// the staging file is absent if it was already renamed
+			// before a crash; otherwise finish is retried by recovery.
+			return fmt.Errorf("filemanager: finish: %w", err)
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		err := syncFile(dir)
+		if err != nil {
+			return fmt.Errorf("filemanager: finish: %w", err)
+		}
+	}
+
+	delete(m.txns, txn.ID())
+	if t.journal != nil {
+		t.journal.Close()
+	}
+	err := os.Remove(m.journalPath(t.id))
+	if err != nil {
+		return fmt.Errorf("filemanager: finish: %w", err)
+	}
+	return nil
+}
+
+// TPCAbort implements DataManager.
+func (m *FileManager) TPCAbort(ctx context.Context, txn Transaction) {
+	// staging files that cannot be removed are removed on next open
+	_ = m.abort(txn)
+}
+
+// syncFile syncs file or directory at path to disk.
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// dirList returns sorted names of entries in dir.
+func dirList(t *testing.T, dir string) []string {
+	t.Helper()
+	entryv, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namev := []string{}
+	for _, entry := range entryv {
+		namev = append(namev, entry.Name())
+	}
+	sort.Strings(namev)
+	return namev
+}
+
+func openTestFileManager(t *testing.T) (m *FileManager, dataDir, journalDir string) {
+	t.Helper()
+	dir := t.TempDir()
+	dataDir = filepath.Join(dir, "data")
+	journalDir = filepath.Join(dir, "journal")
+	err := os.Mkdir(dataDir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = OpenFileManager("files", journalDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, dataDir, journalDir
+}
+
+func TestFileManagerCommit(t *testing.T) {
+	m, data, journal := openTestFileManager(t)
+	a := filepath.Join(data, "a")
+	b := filepath.Join(data, "b")
+	err := os.WriteFile(a, []byte("old"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := With(context.Background(), func(ctx context.Context) error {
+		if err := m.WriteFile(ctx, a, []byte("new a"), 0o644); err != nil {
+			return err
+		}
+		if err := m.WriteFile(ctx, b, []byte("new b"), 0o644); err != nil {
+			return err
+		}
+		got, err := m.ReadFile(ctx, a)
+		if err != nil || string(got) != "new a" {
+			t.Errorf("read staged: %q, %v", got, err)
+		}
+		got, err = os.ReadFile(a)
+		if err != nil || string(got) != "old" {
+			t.Errorf("staged write is visible: %q, %v", got, err)
+		}
+		return nil
+	})
+	if !ok || err != nil {
+		t.Fatalf("with: ok=%v err=%v", ok, err)
+	}
+
+	for path, want := range map[string]string{a: "new a", b: "new b"} {
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: %q, %v;  want %q", path, got, err, want)
+		}
+	}
+	if have, want := dirList(t, data), []string{"a", "b"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("data dir: %q;  want %q", have, want)
+	}
+	if have := dirList(t, journal); len(have) != 0 {
+		t.Errorf("journal dir: %q", have)
+	}
+}
+
+func TestFileManagerAbort(t *testing.T) {
+	m, data, journal := openTestFileManager(t)
+	errFail := errors.New("fail")
+
+	_, err := With(context.Background(), func(ctx context.Context) error {
+		if err := m.WriteFile(ctx, filepath.Join(data, "a"), []byte("a"), 0o644); err != nil {
+			return err
+		}
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("with: err=%v", err)
+	}
+
+	if have := dirList(t, data); len(have) != 0 {
+		t.Errorf("data dir: %q", have)
+	}
+	if have := dirList(t, journal); len(have) != 0 {
+		t.Errorf("journal dir: %q", have)
+	}
+}
+
+func TestFileManagerRestart(t *testing.T) {
+	m, data, journal := openTestFileManager(t)
+	a := filepath.Join(data, "a")
+	b := filepath.Join(data, "b")
+
+	// "crash" with one transaction staged and another one prepared
+	_, ctx1 := New(context.Background())
+	if err := m.WriteFile(ctx1, a, []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	txn2, ctx2 := New(context.Background())
+	if err := m.WriteFile(ctx2, b, []byte("2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.TPCVote(ctx2, txn2); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := OpenFileManager("files", journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the staged transaction is cleaned up, the prepared one is in doubt
+	if have, want := dirList(t, data), []string{".b.txn-" + txn2.ID()}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("data dir after restart: %q;  want %q", have, want)
+	}
+	if have, want := m.InDoubt(), []string{txn2.ID()}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("in doubt: %q;  want %q", have, want)
+	}
+
+	// recovery finishes it
+	dlog, _ := openTestDecisionLog(t)
+	for _, rec := range []DecisionRecord{
+		{Txn: txn2.ID(), Kind: DecisionPrepare, Participants: []string{"files"}},
+		{Txn: txn2.ID(), Kind: DecisionCommit},
+	} {
+		if err := dlog.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = Recover(context.Background(), dlog, func(name string) (DataManager, error) {
+		return m, nil
+	})
+	if err != nil {
+		t.Fatalf("recover: %s", err)
+	}
+
+	if have, want := dirList(t, data), []string{"b"}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("data dir after recover: %q;  want %q", have, want)
+	}
+	if have := dirList(t, journal); len(have) != 0 {
+		t.Errorf("journal dir: %q", have)
+	}
+}
@@ -0,0 +1,270 @@
+package atomiccommit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrConflict is returned by KVStore.TPCVote when data accessed by a
+// transaction was changed by another transaction meanwhile.
+//
+// It is retryable.
+var ErrConflict = Retryable(errors.New("kvstore: conflict"))
+
+// KVStore is in-memory transactional key-value store.
+//
+// Modifications made via Put and Delete are buffered per transaction and are
+// applied atomically when the transaction commits. Concurrency control is
+// optimistic: a transaction fails to vote with ErrConflict if any key it read
+// or wrote was changed, or is being committed, by another transaction.
+//
+// KVStore supports savepoints and three-phase commit.
+type KVStore struct {
+	name string
+
+	mu       sync.Mutex
+	data     map[string][]byte // committed data
+	versions map[string]uint64 // key -> version of last committed change; kept after delete
+	version  uint64            // version of last committed transaction
+	locked   map[string]string // key -> id of voted transaction going to change it
+	txns     map[string]*kvTxn // id -> transaction that accessed the store
+}
+
+// kvTxn is state of a transaction in KVStore.
+type kvTxn struct {
+	txn    Transaction
+	seen   map[string]uint64 // key -> version when first accessed
+	writes map[string][]byte // key -> new value; nil means delete
+	voted  bool              // whether seen keys are locked
+}
+
+// NewKVStore creates new empty KVStore.
+//
+// name is returned by Name, so that the store could be used with DecisionLog.
+func NewKVStore(name string) *KVStore {
+	return &KVStore{
+		name:     name,
+		data:     make(map[string][]byte),
+		versions: make(map[string]uint64),
+		locked:   make(map[string]string),
+		txns:     make(map[string]*kvTxn),
+	}
+}
+
+// Name implements NamedDataManager.
+func (s *KVStore) Name() string { return s.name }
+
+// access returns state of current transaction in ctx, joining the store to
+// it on first access, and notes that the transaction accessed key.
+//
+// must be called with .mu held.
+func (s *KVStore) access(ctx context.Context, key string) *kvTxn {
+	txn := Current(ctx)
+	t, ok := s.txns[txn.ID()]
+	if !ok {
+		txn.Join(s)
+		t = &kvTxn{
+			txn:    txn,
+			seen:   make(map[string]uint64),
+			writes: make(map[string][]byte),
+		}
+		s.txns[txn.ID()] = t
+	}
+	if t.voted {
+		panic("kvstore: access after transaction commit began")
+	}
+	if _, ok := t.seen[key]; !ok {
+		t.seen[key] = s.versions[key]
+	}
+	return t
+}
+
+// Get returns value of key as seen by current transaction in ctx.
+func (s *KVStore) Get(ctx context.Context, key string) (value []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.access(ctx, key)
+	value, ok = t.writes[key]
+	if !ok {
+		value, ok = s.data[key]
+	}
+	if value == nil {
+		return nil, false
+	}
+	return bytes.Clone(value), true
+}
+
+// Put sets value of key in current transaction in ctx.
+func (s *KVStore) Put(ctx context.Context, key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.access(ctx, key)
+	value = bytes.Clone(value)
+	if value == nil {
+		value = []byte{}
+	}
+	t.writes[key] = value
+}
+
+// Delete deletes key in current transaction in ctx.
+func (s *KVStore) Delete(ctx context.Context, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.access(ctx, key)
+	t.writes[key] = nil
+}
+
+// Load returns committed value of key outside of any transaction.
+func (s *KVStore) Load(key string) (value []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok = s.data[key]
+	return bytes.Clone(value), ok
+}
+
+// forget releases state of transaction txn.
+//
+// must be called with .mu held.
+func (s *KVStore) forget(txn Transaction) {
+	t, ok := s.txns[txn.ID()]
+	if !ok {
+		return
+	}
+	if t.voted {
+		for key := range t.seen {
+			delete(s.locked, key)
+		}
+	}
+	delete(s.txns, txn.ID())
+}
+
+// Abort implements DataManager.
+func (s *KVStore) Abort(txn Transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forget(txn)
+}
+
+// TPCBegin implements DataManager.
+func (s *KVStore) TPCBegin(txn Transaction) {}
+
+// Commit implements DataManager.
+//
+// Modifications are already buffered in the transaction.
+func (s *KVStore) Commit(ctx context.Context, txn Transaction) error {
+	return nil
+}
+
+// TPCVote implements DataManager.
+//
+// It locks all keys accessed by txn until the transaction is finished or aborted.
+func (s *KVStore) TPCVote(ctx context.Context, txn Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.txns[txn.ID()]
+	if !ok {
+		return fmt.Errorf("kvstore: vote: unknown transaction %s", txn.ID())
+	}
+
+	for key, version := range t.seen {
+		if s.versions[key] != version {
+			return fmt.Errorf("%w: key %q was changed", ErrConflict, key)
+		}
+		if _, locked := s.locked[key]; locked {
+			return fmt.Errorf("%w: key %q is being committed", ErrConflict, key)
+		}
+	}
+
+	for key := range t.seen {
+		s.locked[key] = txn.ID()
+	}
+	t.voted = true
+	return nil
+}
+
+// TPCPreCommit implements PreCommitter.
+func (s *KVStore) TPCPreCommit(ctx context.Context, txn Transaction) error {
+	return nil
+}
+
+// TPCFinish implements DataManager.
+func (s *KVStore) TPCFinish(ctx context.Context, txn Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.txns[txn.ID()]
+	if !ok {
+		// already finished
+		return nil
+	}
+	if !t.voted {
+		return fmt.Errorf("kvstore: finish: transaction %s did not vote", txn.ID())
+	}
+
+	s.version++
+	for key, value := range t.writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+		s.versions[key] = s.version
+	}
+	s.forget(txn)
+	return nil
+}
+
+// TPCAbort implements DataManager.
+func (s *KVStore) TPCAbort(ctx context.Context, txn Transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forget(txn)
+}
+
+// kvSavepoint is KVStore state of a transaction remembered by Savepoint.
+type kvSavepoint struct {
+	s      *KVStore
+	t      *kvTxn
+	writes map[string][]byte
+}
+
+// Savepoint implements SavepointDataManager.
+func (s *KVStore) Savepoint(txn Transaction) (DataManagerSavepoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.txns[txn.ID()]
+	if !ok {
+		return nil, fmt.Errorf("kvstore: savepoint: unknown transaction %s", txn.ID())
+	}
+	return &kvSavepoint{s: s, t: t, writes: cloneWrites(t.writes)}, nil
+}
+
+// Rollback implements DataManagerSavepoint.
+//
+// Keys accessed after the savepoint stay in conflict detection set.
+func (sp *kvSavepoint) Rollback() error {
+	sp.s.mu.Lock()
+	defer sp.s.mu.Unlock()
+
+	sp.t.writes = cloneWrites(sp.writes)
+	return nil
+}
+
+func cloneWrites(writes map[string][]byte) map[string][]byte {
+	clone := make(map[string][]byte, len(writes))
+	for key, value := range writes {
+		clone[key] = value
+	}
+	return clone
+}
@@ -0,0 +1,129 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// kvLoad returns committed value of key as string; "ø" if key is absent.
+func kvLoad(s *KVStore, key string) string {
+	value, ok := s.Load(key)
+	if !ok {
+		return "ø"
+	}
+	return string(value)
+}
+
+func TestKVStoreCommitAbort(t *testing.T) {
+	s := NewKVStore("kv")
+	bg := context.Background()
+
+	ok, err := With(bg, func(ctx context.Context) error {
+		s.Put(ctx, "a", []byte("1"))
+		s.Put(ctx, "b", []byte("2"))
+		if v, _ := s.Get(ctx, "a"); string(v) != "1" {
+			t.Errorf("get own write: %q", v)
+		}
+		if v := kvLoad(s, "a"); v != "ø" {
+			t.Errorf("uncommitted write is visible: %q", v)
+		}
+		return nil
+	})
+	if !ok || err != nil {
+		t.Fatalf("with: ok=%v err=%v", ok, err)
+	}
+	if a, b := kvLoad(s, "a"), kvLoad(s, "b"); a != "1" || b != "2" {
+		t.Fatalf("after commit: a=%s b=%s", a, b)
+	}
+
+	errFail := errors.New("fail")
+	_, err = With(bg, func(ctx context.Context) error {
+		s.Delete(ctx, "a")
+		s.Put(ctx, "b", []byte("3"))
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("with: err=%v", err)
+	}
+	if a, b := kvLoad(s, "a"), kvLoad(s, "b"); a != "1" || b != "2" {
+		t.Fatalf("after abort: a=%s b=%s", a, b)
+	}
+
+	_, err = With(bg, func(ctx context.Context) error {
+		s.Delete(ctx, "a")
+		if _, ok := s.Get(ctx, "a"); ok {
+			t.Errorf("get own delete: key is present")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := kvLoad(s, "a"); a != "ø" {
+		t.Fatalf("after delete: a=%s", a)
+	}
+}
+
+func TestKVStoreConflict(t *testing.T) {
+	s := NewKVStore("kv")
+	bg := context.Background()
+
+	txn1, ctx1 := New(bg)
+	txn2, ctx2 := New(bg)
+
+	// both transactions read-modify-write the same key
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		v, _ := s.Get(ctx, "x")
+		s.Put(ctx, "x", append(v, '+'))
+	}
+
+	if err := txn1.Commit(ctx1); err != nil {
+		t.Fatalf("commit 1: %s", err)
+	}
+	err := txn2.Commit(ctx2)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("commit 2: err=%v;  want conflict", err)
+	}
+	if x := kvLoad(s, "x"); x != "+" {
+		t.Fatalf("x=%s", x)
+	}
+
+	// a transaction that only read data of voted transaction also conflicts
+	txn3, ctx3 := New(bg)
+	txn4, ctx4 := New(bg)
+	s.Put(ctx3, "y", []byte("3"))
+	s.Get(ctx4, "y")
+	s.Put(ctx4, "z", []byte("4"))
+	if err := s.TPCVote(ctx3, txn3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TPCVote(ctx4, txn4); !errors.Is(err, ErrConflict) {
+		t.Fatalf("vote 4: err=%v;  want conflict", err)
+	}
+	s.TPCAbort(ctx3, txn3)
+	if err := s.TPCVote(ctx4, txn4); err != nil {
+		t.Fatalf("vote 4 after abort 3: %s", err)
+	}
+	s.TPCAbort(ctx4, txn4)
+}
+
+func TestKVStoreSavepoint(t *testing.T) {
+	s := NewKVStore("kv")
+
+	ok, err := With(context.Background(), func(ctx context.Context) error {
+		s.Put(ctx, "a", []byte("1"))
+		_, _ = With(ctx, func(ctx context.Context) error {
+			s.Put(ctx, "a", []byte("2"))
+			s.Put(ctx, "b", []byte("2"))
+			return errors.New("nested failed")
+		})
+		return nil
+	})
+	if !ok || err != nil {
+		t.Fatalf("with: ok=%v err=%v", ok, err)
+	}
+	if a, b := kvLoad(s, "a"), kvLoad(s, "b"); a != "1" || b != "ø" {
+		t.Fatalf("a=%s b=%s", a, b)
+	}
+}
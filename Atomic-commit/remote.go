@@ -0,0 +1,338 @@
+package atomiccommit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	messagepassing "github.com/zacksfF/Distributed-Systems-patterns/message-passing"
+)
+
+// Operations of remote participant protocol.
+//
+// Each request is one message {op, txn} sent to ParticipantServer, which
+// answers with one message {err}.
+const (
+	remoteAbort     = "abort"
+	remoteTPCBegin  = "tpc_begin"
+	remoteCommit    = "commit"
+	remoteTPCVote   = "tpc_vote"
+	remoteTPCPre    = "tpc_precommit"
+	remoteTPCFinish = "tpc_finish"
+	remoteTPCAbort  = "tpc_abort"
+)
+
+type remoteRequest struct {
+	Op  string `json:"op"`
+	Txn string `json:"txn"`
+}
+
+type remoteResponse struct {
+	Err       string `json:"err,omitempty"`
+	Retryable bool   `json:"retryable,omitempty"` // whether Err is retryable
+}
+
+// RemoteDataManager is DataManager that runs two-phase commit on a
+// participant served by ParticipantServer on the other side of conn.
+type RemoteDataManager struct {
+	name string
+
+	mu     sync.Mutex // serializes request/response exchanges over conn
+	conn   messagepassing.Connection
+	broken error // I/O error after which requests and responses can be out of step
+
+	// transactions for which TPCBegin could not be delivered.
+	// The error is reported by subsequent Commit.
+	beginErr map[string]error
+}
+
+// NewRemoteDataManager creates new RemoteDataManager talking over conn.
+//
+// name is returned by Name, so that the remote participant could be used with
+// DecisionLog.
+func NewRemoteDataManager(name string, conn messagepassing.Connection) *RemoteDataManager {
+	return &RemoteDataManager{
+		name:     name,
+		conn:     conn,
+		beginErr: make(map[string]error),
+	}
+}
+
+// call sends request op about txn to the participant and waits for its reply.
+//
+// If ctx is done before the reply comes, conn is closed to interrupt the
+// exchange. After any I/O error the connection is considered broken and all
+// further calls fail: a reply to an interrupted request could otherwise be
+// taken for reply to the next one.
+func (r *RemoteDataManager) call(ctx context.Context, op string, txn Transaction) error {
+	req, err := json.Marshal(remoteRequest{Op: op, Txn: txn.ID()})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.broken != nil {
+		return fmt.Errorf("transaction: remote %s: %s: connection broken: %w", r.name, op, r.broken)
+	}
+
+	var data []byte
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err = r.conn.WriteMessage(req)
+		if err == nil {
+			data, err = r.conn.ReadMessage()
+		}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		r.conn.Close()
+		<-done
+		err = ctx.Err()
+	}
+	if err != nil {
+		r.broken = err
+		return fmt.Errorf("transaction: remote %s: %s: %w", r.name, op, err)
+	}
+
+	var resp remoteResponse
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return fmt.Errorf("transaction: remote %s: %s: invalid response: %w", r.name, op, err)
+	}
+	if resp.Err != "" {
+		err = errors.New(resp.Err)
+		if resp.Retryable {
+			err = Retryable(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Name implements NamedDataManager.
+func (r *RemoteDataManager) Name() string { return r.name }
+
+// Abort implements DataManager.
+func (r *RemoteDataManager) Abort(txn Transaction) {
+	// participant that cannot be reached has not voted; it aborts on its
+	// own when ParticipantServer.Serve notices the connection is gone
+	_ = r.call(context.Background(), remoteAbort, txn)
+}
+
+// TPCBegin implements DataManager.
+func (r *RemoteDataManager) TPCBegin(txn Transaction) {
+	err := r.call(context.Background(), remoteTPCBegin, txn)
+	if err != nil {
+		r.mu.Lock()
+		r.beginErr[txn.ID()] = err
+		r.mu.Unlock()
+	}
+}
+
+// Commit implements DataManager.
+func (r *RemoteDataManager) Commit(ctx context.Context, txn Transaction) error {
+	r.mu.Lock()
+	err, ok := r.beginErr[txn.ID()]
+	delete(r.beginErr, txn.ID())
+	r.mu.Unlock()
+	if ok {
+		return err
+	}
+
+	return r.call(ctx, remoteCommit, txn)
+}
+
+// TPCVote implements DataManager.
+func (r *RemoteDataManager) TPCVote(ctx context.Context, txn Transaction) error {
+	return r.call(ctx, remoteTPCVote, txn)
+}
+
+// TPCPreCommit implements PreCommitter.
+//
+// The participant must be PreCommitter on the server side.
+func (r *RemoteDataManager) TPCPreCommit(ctx context.Context, txn Transaction) error {
+	return r.call(ctx, remoteTPCPre, txn)
+}
+
+// TPCFinish implements DataManager.
+func (r *RemoteDataManager) TPCFinish(ctx context.Context, txn Transaction) error {
+	return r.call(ctx, remoteTPCFinish, txn)
+}
+
+// TPCAbort implements DataManager.
+func (r *RemoteDataManager) TPCAbort(ctx context.Context, txn Transaction) {
+	r.mu.Lock()
+	delete(r.beginErr, txn.ID())
+	r.mu.Unlock()
+
+	// participant that cannot be reached is aborted by recovery
+	_ = r.call(ctx, remoteTPCAbort, txn)
+}
+
+// ParticipantServer serves two-phase commit requests of RemoteDataManager
+// on behalf of a local DataManager.
+type ParticipantServer struct {
+	dm DataManager
+
+	mu   sync.Mutex
+	txns map[string]*transaction // id -> local image of remote transaction
+}
+
+// NewParticipantServer creates new ParticipantServer for dm.
+func NewParticipantServer(dm DataManager) *ParticipantServer {
+	return &ParticipantServer{
+		dm:   dm,
+		txns: make(map[string]*transaction),
+	}
+}
+
+// Txn returns local image of remote transaction with provided id.
+//
+// The data manager should be modified under returned transaction, so that
+// following two-phase commit requests refer to the same Transaction.
+func (s *ParticipantServer) Txn(id string) Transaction {
+	return s.txn(id)
+}
+
+func (s *ParticipantServer) txn(id string) *transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	txn, ok := s.txns[id]
+	if !ok {
+		txn = &transaction{id: id, status: Active}
+		s.txns[id] = txn
+	}
+	return txn
+}
+
+// forget removes local image of completed transaction.
+func (s *ParticipantServer) forget(txn *transaction, status Status) {
+	txn.setStatus(status)
+
+	s.mu.Lock()
+	delete(s.txns, txn.id)
+	s.mu.Unlock()
+}
+
+// Serve serves requests coming over conn until conn is closed.
+//
+// Transactions that were started over conn but not yet voted for are aborted
+// when Serve returns: the coordinator cannot have committed them. Voted
+// transactions stay in doubt until the coordinator, or its recovery, comes
+// back.
+func (s *ParticipantServer) Serve(ctx context.Context, conn messagepassing.Connection) error {
+	unvoted := make(map[string]bool) // ids of transactions to abort on disconnect
+	defer s.abortUnvoted(context.WithoutCancel(ctx), unvoted)
+
+	for {
+		data, err := conn.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req remoteRequest
+		var resp remoteResponse
+		err = json.Unmarshal(data, &req)
+		if err == nil {
+			err = s.handle(ctx, req)
+			// a transaction that failed to finish has voted: it
+			// stays in doubt, not to be aborted on disconnect
+			switch {
+			case req.Op == remoteTPCVote && err == nil,
+				req.Op == remoteAbort, req.Op == remoteTPCFinish, req.Op == remoteTPCAbort:
+				delete(unvoted, req.Txn)
+			case req.Op != remoteTPCVote && req.Op != remoteTPCPre:
+				unvoted[req.Txn] = true
+			}
+		}
+		if err != nil {
+			resp.Err = err.Error()
+			resp.Retryable = IsRetryable(err)
+		}
+
+		data, err = json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		err = conn.WriteMessage(data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// abortUnvoted aborts transactions with provided ids that are still in progress.
+func (s *ParticipantServer) abortUnvoted(ctx context.Context, ids map[string]bool) {
+	for id := range ids {
+		s.mu.Lock()
+		txn, ok := s.txns[id]
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		begun := txn.Status() == Commiting
+		txn.setStatus(Aborting)
+		if begun {
+			s.dm.TPCAbort(ctx, txn)
+		} else {
+			s.dm.Abort(txn)
+		}
+		s.forget(txn, Aborted)
+	}
+}
+
+// handle runs one request on the local data manager.
+func (s *ParticipantServer) handle(ctx context.Context, req remoteRequest) error {
+	switch req.Op {
+	case remoteAbort, remoteTPCBegin, remoteCommit, remoteTPCVote, remoteTPCPre, remoteTPCFinish, remoteTPCAbort:
+		// ok
+	default:
+		return fmt.Errorf("transaction: remote: invalid operation %q", req.Op)
+	}
+
+	txn := s.txn(req.Txn)
+	switch req.Op {
+	case remoteAbort:
+		txn.setStatus(Aborting)
+		s.dm.Abort(txn)
+		s.forget(txn, Aborted)
+	case remoteTPCBegin:
+		txn.setStatus(Commiting)
+		s.dm.TPCBegin(txn)
+	case remoteCommit:
+		return s.dm.Commit(ctx, txn)
+	case remoteTPCVote:
+		return s.dm.TPCVote(ctx, txn)
+	case remoteTPCPre:
+		pc, ok := s.dm.(PreCommitter)
+		if !ok {
+			return fmt.Errorf("transaction: remote: %T is not a PreCommitter", s.dm)
+		}
+		return pc.TPCPreCommit(ctx, txn)
+	case remoteTPCFinish:
+		err := s.dm.TPCFinish(ctx, txn)
+		if err != nil {
+			// the transaction stays in doubt until coordinator
+			// recovery finishes it again
+			return err
+		}
+		s.forget(txn, Commited)
+	case remoteTPCAbort:
+		txn.setStatus(Aborting)
+		s.dm.TPCAbort(ctx, txn)
+		s.forget(txn, Aborted)
+	}
+	return nil
+}
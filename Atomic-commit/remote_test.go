@@ -0,0 +1,297 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	messagepassing "github.com/zacksfF/Distributed-Systems-patterns/message-passing"
+)
+
+// pipeConn is in-memory messagepassing.Connection.
+type pipeConn struct {
+	in, out   chan []byte
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// pipe returns two connected ends of in-memory connection.
+func pipe() (*pipeConn, *pipeConn) {
+	a, b := make(chan []byte), make(chan []byte)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	return &pipeConn{a, b, closed, once}, &pipeConn{b, a, closed, once}
+}
+
+func (p *pipeConn) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-p.in:
+		return msg, nil
+	case <-p.closed:
+		return nil, io.EOF
+	}
+}
+
+func (p *pipeConn) WriteMessage(msg []byte) error {
+	select {
+	case p.out <- msg:
+		return nil
+	case <-p.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (p *pipeConn) Read(b []byte) (int, error) {
+	msg, err := p.ReadMessage()
+	return copy(b, msg), err
+}
+
+func (p *pipeConn) Write(b []byte) (int, error) {
+	err := p.WriteMessage(b)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *pipeConn) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *pipeConn) OtherClient() string { return "pipe" }
+
+var _ messagepassing.Connection = (*pipeConn)(nil)
+
+// serveRemote serves dm over in-memory connection and returns its remote participant.
+func serveRemote(t *testing.T, name string, dm DataManager) (*RemoteDataManager, *ParticipantServer) {
+	t.Helper()
+	local, remote := pipe()
+	srv := NewParticipantServer(dm)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(context.Background(), remote)
+	}()
+	t.Cleanup(func() {
+		local.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serve %s: %s", name, err)
+		}
+	})
+
+	return NewRemoteDataManager(name, local), srv
+}
+
+func TestRemoteCommit(t *testing.T) {
+	var log []string
+	a, srvA := serveRemote(t, "a", &dmRecord{name: "a", log: &log})
+	b, _ := serveRemote(t, "b", &dmRecord{name: "b", log: &log})
+
+	txn, ctx := New(context.Background())
+	txn.Join(a)
+	txn.Join(b)
+
+	err := txn.Commit(ctx)
+	if err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+
+	want := []string{
+		"a.begin", "b.begin",
+		"a.commit", "b.commit",
+		"a.vote", "b.vote",
+		"a.finish", "b.finish",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("commit:\nhave: %q\nwant: %q", log, want)
+	}
+
+	if len(srvA.txns) != 0 {
+		t.Fatalf("server keeps completed transactions: %v", srvA.txns)
+	}
+}
+
+func TestRemoteVoteFail(t *testing.T) {
+	var log []string
+	a, _ := serveRemote(t, "a", &dmRecord{name: "a", log: &log})
+	b, _ := serveRemote(t, "b", &dmRecord{name: "b", log: &log, voteErr: errors.New("b: conflict")})
+
+	txn, ctx := New(context.Background())
+	txn.Join(a)
+	txn.Join(b)
+
+	err := txn.Commit(ctx)
+	if err == nil || err.Error() != "b: conflict" {
+		t.Fatalf("commit: err=%v", err)
+	}
+	if txn.Status() != Aborted {
+		t.Fatalf("commit: txn.Status=%v", txn.Status())
+	}
+
+	want := []string{
+		"a.begin", "b.begin",
+		"a.commit", "b.commit",
+		"a.vote", "b.vote",
+		"b.abort",
+		"a.tpcabort", "b.tpcabort",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("commit:\nhave: %q\nwant: %q", log, want)
+	}
+}
+
+func TestRemoteDisconnected(t *testing.T) {
+	var log []string
+	a, _ := serveRemote(t, "a", &dmRecord{name: "a", log: &log})
+	a.conn.Close()
+
+	txn, ctx := New(context.Background())
+	txn.Join(a)
+
+	err := txn.Commit(ctx)
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("commit: err=%v", err)
+	}
+	if txn.Status() != Aborted {
+		t.Fatalf("commit: txn.Status=%v", txn.Status())
+	}
+}
+
+// dmOutcome is PreCommitter that reports how transaction completed.
+type dmOutcome struct {
+	outcome chan string
+}
+
+func (d *dmOutcome) Abort(_ Transaction)                                 {}
+func (d *dmOutcome) TPCBegin(_ Transaction)                              {}
+func (d *dmOutcome) Commit(_ context.Context, _ Transaction) error       { return nil }
+func (d *dmOutcome) TPCVote(_ context.Context, _ Transaction) error      { return nil }
+func (d *dmOutcome) TPCPreCommit(_ context.Context, _ Transaction) error { return nil }
+func (d *dmOutcome) TPCFinish(_ context.Context, _ Transaction) error {
+	d.outcome <- "finish"
+	return nil
+}
+func (d *dmOutcome) TPCAbort(_ context.Context, _ Transaction) { d.outcome <- "abort" }
+
+// dmBlockCommit is dmOutcome whose Commit waits until released.
+type dmBlockCommit struct {
+	dmOutcome
+	release chan struct{}
+}
+
+func (d *dmBlockCommit) Commit(_ context.Context, _ Transaction) error {
+	<-d.release
+	return nil
+}
+
+func TestRemoteDeadline(t *testing.T) {
+	dm := &dmBlockCommit{dmOutcome{make(chan string, 1)}, make(chan struct{})}
+	local, remote := pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewParticipantServer(dm).Serve(context.Background(), remote)
+	}()
+	a := NewRemoteDataManager("a", local)
+
+	txn, _ := New(context.Background())
+	a.TPCBegin(txn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := a.Commit(ctx, txn)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("commit: err=%v", err)
+	}
+
+	// late reply to commit must not be taken for reply to the vote
+	close(dm.release)
+	err = a.TPCVote(context.Background(), txn)
+	if err == nil {
+		t.Fatal("vote over broken connection: no error")
+	}
+
+	// the participant has not voted and aborts on its own
+	<-done
+	if outcome := <-dm.outcome; outcome != "abort" {
+		t.Fatalf("outcome: %s;  want abort", outcome)
+	}
+}
+
+func TestRemoteDisconnectVoted(t *testing.T) {
+	dm := &dmOutcome{make(chan string, 1)}
+	local, remote := pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewParticipantServer(dm).Serve(context.Background(), remote)
+	}()
+	a := NewRemoteDataManager("a", local)
+
+	txn, ctx := New(context.Background())
+	a.TPCBegin(txn)
+	err := a.Commit(ctx, txn)
+	if err == nil {
+		err = a.TPCVote(ctx, txn)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// voted participant stays in doubt when coordinator goes away
+	local.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+	select {
+	case outcome := <-dm.outcome:
+		t.Fatalf("voted participant completed on disconnect: %s", outcome)
+	default:
+	}
+}
+
+// dmFinishOnce is dmRecord whose first TPCFinish fails; it records
+// transactions it was finished under.
+type dmFinishOnce struct {
+	dmRecord
+	failed bool
+	txnv   []Transaction
+}
+
+func (d *dmFinishOnce) TPCFinish(ctx context.Context, txn Transaction) error {
+	d.txnv = append(d.txnv, txn)
+	if !d.failed {
+		d.failed = true
+		return errors.New("finish failed")
+	}
+	return d.dmRecord.TPCFinish(ctx, txn)
+}
+
+func TestRemoteFinishFails(t *testing.T) {
+	var log []string
+	dm := &dmFinishOnce{dmRecord: dmRecord{name: "a", log: &log}}
+	a, srv := serveRemote(t, "a", dm)
+
+	txn, ctx := New(context.Background())
+	txn.Join(a)
+	if err := txn.Commit(ctx); err == nil {
+		t.Fatal("commit: finish failure was not reported")
+	}
+	if len(srv.txns) != 1 {
+		t.Fatalf("server forgot transaction that failed to finish: %v", srv.txns)
+	}
+
+	// coordinator recovery finishes the same in-doubt transaction again
+	err := a.TPCFinish(ctx, txn)
+	if err != nil {
+		t.Fatalf("re-driven finish: %s", err)
+	}
+	if len(dm.txnv) != 2 || dm.txnv[0] != dm.txnv[1] || dm.txnv[1].Status() != Commited {
+		t.Fatalf("finished under %v", dm.txnv)
+	}
+	if len(srv.txns) != 0 {
+		t.Fatalf("server keeps completed transactions: %v", srv.txns)
+	}
+}
@@ -0,0 +1,97 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// retryableError marks error as retryable.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string   { return e.err.Error() }
+func (e *retryableError) Unwrap() error   { return e.err }
+func (e *retryableError) Retryable() bool { return true }
+
+// Retryable marks err as retryable: transaction that failed with it may
+// succeed if run again, as it happens e.g. on write conflicts.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err}
+}
+
+// IsRetryable reports whether err, or any error it wraps, is marked retryable.
+//
+// An error is retryable if it has method Retryable() bool returning true.
+func IsRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	return errors.As(err, &r) && r.Retryable()
+}
+
+// RetryPolicy tells WithRetry how to run transactions again.
+type RetryPolicy struct {
+	MaxAttempts int           // how many times to run transaction at most
+	BaseDelay   time.Duration // delay before second attempt
+	MaxDelay    time.Duration // upper bound of delay between attempts; zero means no bound
+}
+
+// DefaultRetryPolicy is retry policy suitable for optimistic data managers.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// delay returns how long to wait before attempt n+1.
+//
+// The delay grows exponentially with n and is randomized in [d/2, d] so that
+// conflicting transactions do not retry in lockstep.
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// WithRetry is like With, but runs f again in a new transaction when the
+// transaction fails with retryable error, either returned by f, or by
+// participants when committing.
+//
+// f is run at most policy.MaxAttempts times with exponential backoff between
+// attempts. WithRetry stops waiting for next attempt when ctx is done.
+//
+// If ctx already has a transaction, f is run only once in nested scope of it,
+// as the outer transaction has to be retried as a whole.
+func WithRetry(ctx context.Context, policy RetryPolicy, f func(context.Context) error, optv ...Option) (ok bool, _ error) {
+	if getTxn(ctx) != nil {
+		return With(ctx, f, optv...)
+	}
+
+	for n := 1; ; n++ {
+		ok, err := With(ctx, f, optv...)
+		if err == nil || !IsRetryable(err) || n >= policy.MaxAttempts {
+			return ok, err
+		}
+
+		timer := time.NewTimer(policy.delay(n))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, errors.Join(err, context.Cause(ctx))
+		case <-timer.C:
+		}
+	}
+}
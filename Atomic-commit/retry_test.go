@@ -0,0 +1,122 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+
+func TestIsRetryable(t *testing.T) {
+	err := errors.New("x")
+	if IsRetryable(err) || IsRetryable(nil) {
+		t.Fatal("plain error is retryable")
+	}
+	if !IsRetryable(Retryable(err)) {
+		t.Fatal("Retryable(err) is not retryable")
+	}
+	wrapped := errors.Join(errors.New("y"), Retryable(err))
+	if !IsRetryable(wrapped) || !errors.Is(wrapped, err) {
+		t.Fatal("wrapped retryable error")
+	}
+}
+
+func TestWithRetryConflict(t *testing.T) {
+	s := NewKVStore("kv")
+	bg := context.Background()
+
+	attempts := 0
+	ok, err := WithRetry(bg, testRetryPolicy, func(ctx context.Context) error {
+		attempts++
+		v, _ := s.Get(ctx, "x")
+		s.Put(ctx, "x", append(v, 'a'))
+
+		// a concurrent transaction changes x during the first attempt
+		if attempts == 1 {
+			_, err := With(bg, func(ctx context.Context) error {
+				s.Put(ctx, "x", []byte("b"))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	})
+	if !ok || err != nil {
+		t.Fatalf("with retry: ok=%v err=%v", ok, err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts: %d;  want 2", attempts)
+	}
+	if x := kvLoad(s, "x"); x != "ba" {
+		t.Fatalf("x=%s", x)
+	}
+}
+
+func TestWithRetryLimits(t *testing.T) {
+	bg := context.Background()
+
+	// non-retryable error is not retried
+	attempts := 0
+	errFail := errors.New("fail")
+	_, err := WithRetry(bg, testRetryPolicy, func(ctx context.Context) error {
+		attempts++
+		return errFail
+	})
+	if err != errFail || attempts != 1 {
+		t.Fatalf("non-retryable: err=%v attempts=%d", err, attempts)
+	}
+
+	// retryable error is retried at most MaxAttempts times
+	attempts = 0
+	errRetry := Retryable(errors.New("retry"))
+	_, err = WithRetry(bg, testRetryPolicy, func(ctx context.Context) error {
+		attempts++
+		return errRetry
+	})
+	if err != errRetry || attempts != testRetryPolicy.MaxAttempts {
+		t.Fatalf("retryable: err=%v attempts=%d", err, attempts)
+	}
+
+	// retries stop when ctx is canceled
+	attempts = 0
+	ctx, cancel := context.WithCancel(bg)
+	slow := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	_, err = WithRetry(ctx, slow, func(ctx context.Context) error {
+		attempts++
+		cancel()
+		return errRetry
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, errRetry) || attempts != 1 {
+		t.Fatalf("canceled: err=%v attempts=%d", err, attempts)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for n, max := range []time.Duration{10, 20, 40, 50, 50} {
+		max *= time.Millisecond
+		d := p.delay(n + 1)
+		if d < max/2 || d > max {
+			t.Errorf("delay(%d) = %v;  want in [%v, %v]", n+1, d, max/2, max)
+		}
+	}
+}
+
+func TestRetryPolicyDelayUnbounded(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond}
+	for n, max := range []time.Duration{10, 20, 40, 80, 160} {
+		max *= time.Millisecond
+		d := p.delay(n + 1)
+		if d < max/2 || d > max {
+			t.Errorf("delay(%d) = %v;  want in [%v, %v]", n+1, d, max/2, max)
+		}
+	}
+	// doubling stops before the delay overflows
+	if d := p.delay(100); d <= 0 {
+		t.Errorf("delay(100) = %v", d)
+	}
+}
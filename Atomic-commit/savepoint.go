@@ -0,0 +1,153 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Savepoint is a point inside a transaction to which its modifications can
+// be rolled back without aborting the whole transaction.
+type Savepoint interface {
+	// Rollback discards all modifications made in the transaction after
+	// the savepoint.
+	//
+	// The savepoint stays valid and can be rolled back to again, while
+	// savepoints made after it are invalidated.
+	Rollback() error
+}
+
+// SavepointDataManager is a DataManager that supports savepoints.
+type SavepointDataManager interface {
+	DataManager
+
+	// Savepoint remembers current state of modifications made under txn.
+	Savepoint(txn Transaction) (DataManagerSavepoint, error)
+}
+
+// DataManagerSavepoint is state of a data manager remembered by Savepoint.
+type DataManagerSavepoint interface {
+	// Rollback reverts modifications made under the transaction to the
+	// state remembered by the savepoint.
+	//
+	// It can be called several times.
+	Rollback() error
+}
+
+// ErrSavepointInvalid is returned when rolling back to a savepoint that was
+// invalidated by rollback to an earlier savepoint, or whose transaction
+// completion already began.
+var ErrSavepointInvalid = errors.New("transaction: savepoint is no longer valid")
+
+// savepoint implements Savepoint.
+type savepoint struct {
+	txn   *transaction
+	ndata int                    // len(txn.datav) when savepoint was made
+	datav []DataManagerSavepoint // savepoints of txn.datav[:ndata] that support them
+	nosp  []DataManager          // txn.datav[:ndata] that do not support savepoints
+}
+
+// Savepoint implements Transaction.
+//
+// Data managers joined to the transaction that are not SavepointDataManagers
+// cannot be rolled back: rollback to the savepoint fails and dooms the
+// transaction if there are any. Savepoints that are never rolled back do not
+// need their support.
+func (txn *transaction) Savepoint() (Savepoint, error) {
+	txn.mu.Lock()
+	txn.checkNotYetCompleting("savepoint")
+	datav := txn.datav
+	txn.mu.Unlock()
+
+	sp := &savepoint{txn: txn, ndata: len(datav)}
+	for _, dm := range datav {
+		spdm, ok := dm.(SavepointDataManager)
+		if !ok {
+			sp.nosp = append(sp.nosp, dm)
+			continue
+		}
+		dmsp, err := spdm.Savepoint(txn)
+		if err != nil {
+			return nil, fmt.Errorf("transaction: savepoint: %w", err)
+		}
+		sp.datav = append(sp.datav, dmsp)
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.checkNotYetCompleting("savepoint")
+	txn.savepointv = append(txn.savepointv, sp)
+	return sp, nil
+}
+
+// Rollback implements Savepoint.
+//
+// Data managers joined after the savepoint are aborted and leave the
+// transaction; they join it again on their next modification.
+func (sp *savepoint) Rollback() error {
+	txn := sp.txn
+
+	var joined []DataManager
+	err := func() error {
+		txn.mu.Lock()
+		defer txn.mu.Unlock()
+
+		if !(txn.status == Active || txn.status == Doomed) {
+			return ErrSavepointInvalid
+		}
+		i := 0
+		for ; i < len(txn.savepointv); i++ {
+			if txn.savepointv[i] == sp {
+				break
+			}
+		}
+		if i == len(txn.savepointv) {
+			return ErrSavepointInvalid
+		}
+
+		txn.savepointv = txn.savepointv[:i+1]
+		joined = txn.datav[sp.ndata:]
+		txn.datav = txn.datav[:sp.ndata:sp.ndata]
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, dm := range joined {
+		dm.Abort(txn)
+	}
+
+	var errv []error
+	for _, dm := range sp.nosp {
+		errv = append(errv, fmt.Errorf("transaction: rollback: %T does not support savepoints", dm))
+	}
+	for _, dmsp := range sp.datav {
+		err := dmsp.Rollback()
+		if err != nil {
+			errv = append(errv, err)
+		}
+	}
+	if len(errv) != 0 {
+		// modifications made after the savepoint may be left in place
+		txn.Doom()
+	}
+	return errors.Join(errv...)
+}
+
+// withSavepoint serves With called inside already running transaction.
+func withSavepoint(ctx context.Context, txn *transaction, f func(context.Context) error) (ok bool, _ error) {
+	sp, err := txn.Savepoint()
+	if err != nil {
+		return false, err
+	}
+
+	err = f(ctx)
+	if err != nil {
+		if errRollback := sp.Rollback(); errRollback != nil {
+			err = errors.Join(err, errRollback)
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,164 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// dmList is SavepointDataManager that manages list of committed items.
+type dmList struct {
+	committed []string
+	txn       Transaction
+	pending   []string // items added under txn
+}
+
+// Add adds item to the list under transaction from ctx.
+func (d *dmList) Add(ctx context.Context, item string) {
+	if d.txn == nil {
+		d.txn = Current(ctx)
+		d.txn.Join(d)
+	}
+	d.pending = append(d.pending, item)
+}
+
+func (d *dmList) reset() { d.txn, d.pending = nil, nil }
+
+func (d *dmList) Abort(_ Transaction)                            { d.reset() }
+func (d *dmList) TPCBegin(_ Transaction)                         {}
+func (d *dmList) Commit(_ context.Context, _ Transaction) error  { return nil }
+func (d *dmList) TPCVote(_ context.Context, _ Transaction) error { return nil }
+func (d *dmList) TPCAbort(_ context.Context, _ Transaction)      { d.reset() }
+func (d *dmList) TPCFinish(_ context.Context, _ Transaction) error {
+	d.committed = append(d.committed, d.pending...)
+	d.reset()
+	return nil
+}
+
+type dmListSavepoint struct {
+	d *dmList
+	n int
+}
+
+func (d *dmList) Savepoint(_ Transaction) (DataManagerSavepoint, error) {
+	return &dmListSavepoint{d, len(d.pending)}, nil
+}
+
+func (sp *dmListSavepoint) Rollback() error {
+	sp.d.pending = sp.d.pending[:sp.n]
+	return nil
+}
+
+func TestNestedWith(t *testing.T) {
+	a := &dmList{}
+	b := &dmList{}
+	errNested := errors.New("nested failed")
+
+	ok, err := With(context.Background(), func(ctx context.Context) error {
+		a.Add(ctx, "a1")
+
+		ok, err := With(ctx, func(ctx context.Context) error {
+			a.Add(ctx, "a2")
+			b.Add(ctx, "b1")
+			return errNested
+		})
+		if ok || err != errNested {
+			t.Fatalf("nested: ok=%v err=%v", ok, err)
+		}
+
+		ok, err = With(ctx, func(ctx context.Context) error {
+			a.Add(ctx, "a3")
+			b.Add(ctx, "b2")
+			return nil
+		})
+		if !ok || err != nil {
+			t.Fatalf("nested 2: ok=%v err=%v", ok, err)
+		}
+		return nil
+	})
+	if !ok || err != nil {
+		t.Fatalf("with: ok=%v err=%v", ok, err)
+	}
+
+	if want := []string{"a1", "a3"}; !reflect.DeepEqual(a.committed, want) {
+		t.Fatalf("a: %q;  want %q", a.committed, want)
+	}
+	if want := []string{"b2"}; !reflect.DeepEqual(b.committed, want) {
+		t.Fatalf("b: %q;  want %q", b.committed, want)
+	}
+}
+
+func TestSavepointInvalidate(t *testing.T) {
+	a := &dmList{}
+	txn, ctx := New(context.Background())
+
+	a.Add(ctx, "a1")
+	sp1, err := txn.Savepoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Add(ctx, "a2")
+	sp2, err := txn.Savepoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Add(ctx, "a3")
+
+	// rollback to sp1 invalidates sp2, but sp1 stays valid
+	if err := sp1.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp2.Rollback(); err != ErrSavepointInvalid {
+		t.Fatalf("rollback to invalidated savepoint: %v", err)
+	}
+	a.Add(ctx, "a4")
+	if err := sp1.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a1"}; !reflect.DeepEqual(a.committed, want) {
+		t.Fatalf("a: %q;  want %q", a.committed, want)
+	}
+
+	if err := sp1.Rollback(); err != ErrSavepointInvalid {
+		t.Fatalf("rollback after commit: %v", err)
+	}
+}
+
+func TestSavepointNotSupported(t *testing.T) {
+	txn, ctx := New(context.Background())
+	dm := &dmAbortOnly{t: t, txn: txn}
+	dm.Modify()
+	list := &dmList{}
+
+	// nested scope that succeeds does not need savepoints
+	ok, err := With(ctx, func(ctx context.Context) error {
+		list.Add(ctx, "a1")
+		return nil
+	})
+	if !ok || err != nil {
+		t.Fatalf("nested with: ok=%v err=%v", ok, err)
+	}
+
+	// but one that fails cannot undo modifications of dm
+	errNested := errors.New("nested failed")
+	ok, err = With(ctx, func(ctx context.Context) error {
+		list.Add(ctx, "a2")
+		return errNested
+	})
+	if ok || !errors.Is(err, errNested) || err == errNested {
+		t.Fatalf("nested with 2: ok=%v err=%v", ok, err)
+	}
+	if !reflect.DeepEqual(list.pending, []string{"a1"}) {
+		t.Fatalf("nested with 2: pending=%q", list.pending)
+	}
+	if txn.Status() != Doomed {
+		t.Fatalf("nested with 2: txn.Status=%v", txn.Status())
+	}
+
+	txn.Abort()
+}
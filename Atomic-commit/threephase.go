@@ -0,0 +1,500 @@
+package atomiccommit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Protocol is atomic commit protocol run by Transaction.Commit.
+type Protocol int
+
+const (
+	TwoPhase   Protocol = iota // TPCBegin -> Commit -> TPCVote -> TPCFinish
+	ThreePhase                 // TPCBegin -> Commit -> TPCVote -> TPCPreCommit -> TPCFinish
+)
+
+// PreCommitter is a DataManager that can participate in three-phase commit.
+type PreCommitter interface {
+	DataManager
+
+	// TPCPreCommit tells the data manager that all participants voted to
+	// commit the transaction.
+	//
+	// A participant that pre-committed may finish the commit on its own
+	// if it does not hear from the coordinator anymore.
+	TPCPreCommit(ctx context.Context, txn Transaction) error
+}
+
+type protocolKey struct{}
+
+// WithProtocol returns context in which new transactions are committed via
+// protocol p.
+//
+// With ThreePhase every participant of a transaction must be a PreCommitter.
+func WithProtocol(ctx context.Context, p Protocol) context.Context {
+	return context.WithValue(ctx, protocolKey{}, p)
+}
+
+// getProtocol returns commit protocol associated with provided context.
+// TwoPhase is returned if there is no association.
+func getProtocol(ctx context.Context) Protocol {
+	p, _ := ctx.Value(protocolKey{}).(Protocol)
+	return p
+}
+
+// checkProtocol verifies that all participants can take part in txn commit protocol.
+func (txn *transaction) checkProtocol(datav []DataManager) error {
+	if txn.protocol != ThreePhase {
+		return nil
+	}
+	for _, dm := range datav {
+		if _, ok := dm.(PreCommitter); !ok {
+			return fmt.Errorf("transaction: %T participates in three-phase commit, but is not a PreCommitter", dm)
+		}
+	}
+	return nil
+}
+
+// tpcPreCommit runs pre-commit phase of three-phase commit over datav.
+func (txn *transaction) tpcPreCommit(ctx context.Context, datav []DataManager) error {
+	for _, dm := range datav {
+		err := commitCtxErr(ctx)
+		if err == nil {
+			err = dm.(PreCommitter).TPCPreCommit(ctx, txn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParticipantState is state of a transaction at a participant of
+// three-phase commit.
+type ParticipantState int
+
+const (
+	ParticipantUnknown      ParticipantState = iota // transaction is not known
+	ParticipantBegun                                // TPCBegin received
+	ParticipantVoted                                // voted ok; outcome is uncertain
+	ParticipantPreCommitted                         // all participants voted ok
+	ParticipantCommitted                            // committed
+	ParticipantAborted                              // aborted
+)
+
+// ThreePhasePeer is another participant of the same transactions, consulted
+// by ThreePhaseParticipant when the coordinator goes silent.
+type ThreePhasePeer interface {
+	// State returns state of transaction id at the peer.
+	State(id string) (ParticipantState, error)
+
+	// Terminate makes the peer complete transaction id with outcome,
+	// ParticipantCommitted or ParticipantAborted, decided by termination
+	// protocol.
+	Terminate(id string, outcome ParticipantState) error
+}
+
+// outcomeRetention is for how many timeouts ThreePhaseParticipant remembers
+// outcome of a transaction it completed without coordinator.
+const outcomeRetention = 1000
+
+// participantTxn is a transaction in which ThreePhaseParticipant takes part.
+type participantTxn struct {
+	txn   Transaction
+	state ParticipantState
+	timer *time.Timer
+}
+
+// ThreePhaseParticipant is PreCommitter that completes three-phase commit on
+// its own if coordinator goes silent.
+//
+// If no message about a transaction comes from coordinator for the timeout,
+// the participant acts according to its state:
+//
+//   - not voted yet: the transaction is aborted - nobody could have
+//     committed without our vote;
+//   - pre-committed: the transaction is committed - everybody voted ok and
+//     nobody could have aborted;
+//   - voted: the coordinator might have pre-committed some participants
+//     before it went silent, so the participant runs termination protocol
+//     with its peers: the transaction is committed if any peer pre-committed
+//     or committed it, and aborted if any peer aborted it or did not vote or
+//     all peers are uncertain as well. The outcome is imposed on all peers.
+//     If a peer cannot be reached, or peers were not set with SetPeers, the
+//     participant stays uncertain and tries again after the next timeout.
+//
+// If completing the transaction fails, it is retried after the next timeout.
+// The outcome is remembered until the coordinator, if it ever comes back,
+// finishes or aborts the transaction, but for no longer than 1000 timeouts.
+// If the coordinator decided otherwise, the transaction is split: this is
+// logged and the outcome of the participant stays visible through State.
+type ThreePhaseParticipant struct {
+	dm      PreCommitter
+	timeout time.Duration
+
+	mu        sync.Mutex
+	txns      map[string]*participantTxn // id -> in-progress commit
+	expired   map[string]expiredTxn      // id -> outcome of commit completed without coordinator
+	expiredq  []expiredID                // expired in order of completion, for pruning
+	retention time.Duration              // how long outcomes are kept in expired
+
+	peers     []ThreePhasePeer
+	havePeers bool // whether SetPeers was called
+}
+
+// expiredTxn is outcome of a transaction completed without coordinator.
+type expiredTxn struct {
+	state ParticipantState
+	at    time.Time // when the transaction was completed
+}
+
+// expiredID is entry of ThreePhaseParticipant.expiredq.
+type expiredID struct {
+	id string
+	at time.Time
+}
+
+// NewThreePhaseParticipant wraps dm into ThreePhaseParticipant with provided timeout.
+func NewThreePhaseParticipant(dm PreCommitter, timeout time.Duration) *ThreePhaseParticipant {
+	return &ThreePhaseParticipant{
+		dm:        dm,
+		timeout:   timeout,
+		txns:      make(map[string]*participantTxn),
+		expired:   make(map[string]expiredTxn),
+		retention: outcomeRetention * timeout,
+	}
+}
+
+// SetPeers sets other participants of transactions p takes part in, for
+// termination protocol. Calling it with no peers means p is the only
+// participant.
+//
+// It must be called before p takes part in any transaction.
+func (p *ThreePhaseParticipant) SetPeers(peers ...ThreePhasePeer) {
+	p.peers = peers
+	p.havePeers = true
+}
+
+// lookup returns in-progress commit of txn, restarting its timeout.
+//
+// nil is returned if commit of txn was already completed.
+// must be called with .mu held.
+func (p *ThreePhaseParticipant) lookup(txn Transaction) *participantTxn {
+	t, ok := p.txns[txn.ID()]
+	if !ok {
+		return nil
+	}
+	t.timer.Reset(p.timeout)
+	return t
+}
+
+// complete marks in-progress commit of t as completed with final state.
+//
+// must be called with .mu held.
+func (p *ThreePhaseParticipant) complete(t *participantTxn, state ParticipantState) {
+	t.state = state
+	t.timer.Stop()
+	delete(p.txns, t.txn.ID())
+}
+
+// completeAlone completes t with outcome decided without coordinator.
+//
+// On error t stays in progress, in the state it reached.
+// must be called with .mu held.
+func (p *ThreePhaseParticipant) completeAlone(t *participantTxn, outcome ParticipantState) error {
+	ctx := context.Background()
+	switch outcome {
+	case ParticipantCommitted:
+		if t.state == ParticipantVoted {
+			err := p.dm.TPCPreCommit(ctx, t.txn)
+			if err != nil {
+				return err
+			}
+			t.state = ParticipantPreCommitted
+		}
+		err := p.dm.TPCFinish(ctx, t.txn)
+		if err != nil {
+			return err
+		}
+	case ParticipantAborted:
+		if t.state == ParticipantBegun {
+			p.dm.Abort(t.txn)
+		}
+		p.dm.TPCAbort(ctx, t.txn)
+	}
+	p.complete(t, outcome)
+	p.remember(t.txn.ID(), outcome)
+	return nil
+}
+
+// completeOnTimeout is completeAlone called on timeout: failure is logged
+// and completion is retried after the next timeout.
+//
+// must be called with .mu held.
+func (p *ThreePhaseParticipant) completeOnTimeout(t *participantTxn, outcome ParticipantState) {
+	err := p.completeAlone(t, outcome)
+	if err != nil {
+		log.Printf("transaction: %s: completing on timeout: %s; will retry", t.txn.ID(), err)
+		t.timer.Reset(p.timeout)
+	}
+}
+
+// remember records outcome of transaction id completed without coordinator,
+// forgetting outcomes older than retention.
+//
+// must be called with .mu held.
+func (p *ThreePhaseParticipant) remember(id string, outcome ParticipantState) {
+	now := time.Now()
+	for len(p.expiredq) > 0 && now.Sub(p.expiredq[0].at) > p.retention {
+		old := p.expiredq[0]
+		if e, ok := p.expired[old.id]; ok && e.at.Equal(old.at) {
+			delete(p.expired, old.id)
+		}
+		p.expiredq = p.expiredq[1:]
+	}
+	p.expired[id] = expiredTxn{state: outcome, at: now}
+	p.expiredq = append(p.expiredq, expiredID{id: id, at: now})
+}
+
+// settle handles outcome decided by coordinator for txn that was already
+// completed without it.
+//
+// If the outcomes differ, the transaction is split: this is logged and
+// returned as error, and outcome of the participant stays visible through
+// State.
+// must be called with .mu held.
+func (p *ThreePhaseParticipant) settle(who string, txn Transaction, outcome ParticipantState) error {
+	e, ok := p.expired[txn.ID()]
+	if ok && e.state != outcome {
+		err := fmt.Errorf("%w; coordinator %s it", p.errExpired(who, txn), stateOutcome(outcome))
+		log.Print(err)
+		return err
+	}
+	delete(p.expired, txn.ID())
+	return nil
+}
+
+// expire is called when coordinator did not speak about t for timeout.
+func (p *ThreePhaseParticipant) expire(t *participantTxn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.txns[t.txn.ID()] != t {
+		return // completed concurrently
+	}
+
+	switch t.state {
+	case ParticipantBegun:
+		p.completeOnTimeout(t, ParticipantAborted)
+	case ParticipantPreCommitted:
+		p.completeOnTimeout(t, ParticipantCommitted)
+	case ParticipantVoted:
+		if !p.havePeers {
+			t.timer.Reset(p.timeout)
+			return
+		}
+		// peers are asked without .mu held: they may be asking us at
+		// the same time
+		p.mu.Unlock()
+		outcome, ok := p.terminate(t.txn.ID())
+		p.mu.Lock()
+		if p.txns[t.txn.ID()] != t {
+			return // completed concurrently, by coordinator or a peer
+		}
+		if !ok {
+			t.timer.Reset(p.timeout)
+			return
+		}
+		p.completeOnTimeout(t, outcome)
+	}
+}
+
+// terminate runs termination protocol for transaction id we voted for.
+//
+// It decides outcome of the transaction from states of all peers and imposes
+// it on them. ok is false if some peer could not be asked.
+func (p *ThreePhaseParticipant) terminate(id string) (outcome ParticipantState, ok bool) {
+	outcome = ParticipantAborted
+	committed, aborted := false, false
+	for _, peer := range p.peers {
+		state, err := peer.State(id)
+		if err != nil {
+			return 0, false
+		}
+		switch state {
+		case ParticipantPreCommitted, ParticipantCommitted:
+			committed = true
+		case ParticipantUnknown, ParticipantBegun, ParticipantAborted:
+			aborted = true
+		}
+	}
+	if committed && !aborted {
+		outcome = ParticipantCommitted
+	}
+
+	for _, peer := range p.peers {
+		// a peer that fails to complete runs termination on its own
+		// later and arrives to the same outcome
+		_ = peer.Terminate(id, outcome)
+	}
+	return outcome, true
+}
+
+// State implements ThreePhasePeer.
+func (p *ThreePhaseParticipant) State(id string) (ParticipantState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.txns[id]; ok {
+		return t.state, nil
+	}
+	return p.expired[id].state, nil
+}
+
+// Terminate implements ThreePhasePeer.
+func (p *ThreePhaseParticipant) Terminate(id string, outcome ParticipantState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.txns[id]
+	if !ok {
+		if e, ok := p.expired[id]; ok && e.state != outcome {
+			return fmt.Errorf("transaction: terminate: transaction %s was already %s", id, stateOutcome(e.state))
+		}
+		return nil
+	}
+	if (outcome == ParticipantCommitted && t.state == ParticipantBegun) ||
+		(outcome == ParticipantAborted && t.state == ParticipantPreCommitted) {
+		return fmt.Errorf("transaction: terminate: transaction %s cannot be %s", id, stateOutcome(outcome))
+	}
+	return p.completeAlone(t, outcome)
+}
+
+// stateOutcome returns outcome of completed transaction in words.
+func stateOutcome(state ParticipantState) string {
+	if state == ParticipantCommitted {
+		return "committed"
+	}
+	return "aborted"
+}
+
+// errExpired returns error reporting that txn was completed without coordinator.
+//
+// must be called with .mu held.
+func (p *ThreePhaseParticipant) errExpired(who string, txn Transaction) error {
+	return fmt.Errorf("transaction: %s: transaction %s was %s on timeout", who, txn.ID(), stateOutcome(p.expired[txn.ID()].state))
+}
+
+// Abort implements DataManager.
+func (p *ThreePhaseParticipant) Abort(txn Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dm.Abort(txn)
+}
+
+// TPCBegin implements DataManager.
+func (p *ThreePhaseParticipant) TPCBegin(txn Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := &participantTxn{txn: txn, state: ParticipantBegun}
+	t.timer = time.AfterFunc(p.timeout, func() { p.expire(t) })
+	p.txns[txn.ID()] = t
+
+	p.dm.TPCBegin(txn)
+}
+
+// Commit implements DataManager.
+func (p *ThreePhaseParticipant) Commit(ctx context.Context, txn Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lookup(txn) == nil {
+		return p.errExpired("commit", txn)
+	}
+	return p.dm.Commit(ctx, txn)
+}
+
+// TPCVote implements DataManager.
+func (p *ThreePhaseParticipant) TPCVote(ctx context.Context, txn Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.lookup(txn)
+	if t == nil {
+		return p.errExpired("vote", txn)
+	}
+	err := p.dm.TPCVote(ctx, txn)
+	if err != nil {
+		return err
+	}
+	t.state = ParticipantVoted
+	return nil
+}
+
+// TPCPreCommit implements PreCommitter.
+func (p *ThreePhaseParticipant) TPCPreCommit(ctx context.Context, txn Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.lookup(txn)
+	if t == nil {
+		return p.errExpired("pre-commit", txn)
+	}
+	if t.state != ParticipantVoted {
+		return fmt.Errorf("transaction: pre-commit: transaction %s is not voted", txn.ID())
+	}
+	err := p.dm.TPCPreCommit(ctx, txn)
+	if err != nil {
+		return err
+	}
+	t.state = ParticipantPreCommitted
+	return nil
+}
+
+// TPCFinish implements DataManager.
+//
+// It is no-op if the transaction was already committed on timeout, and fails
+// if it was aborted. If the data manager fails to finish, the transaction
+// stays pre-committed: finish is retried on timeout or by coordinator
+// recovery.
+func (p *ThreePhaseParticipant) TPCFinish(ctx context.Context, txn Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.lookup(txn)
+	if t == nil {
+		return p.settle("finish", txn, ParticipantCommitted)
+	}
+	if t.state != ParticipantPreCommitted {
+		return fmt.Errorf("transaction: finish: transaction %s is not pre-committed", txn.ID())
+	}
+	err := p.dm.TPCFinish(ctx, txn)
+	if err != nil {
+		return err
+	}
+	p.complete(t, ParticipantCommitted)
+	return nil
+}
+
+// TPCAbort implements DataManager.
+//
+// It is no-op if the transaction was already aborted on timeout. A
+// transaction committed on timeout cannot be aborted anymore; the split
+// outcome is logged.
+func (p *ThreePhaseParticipant) TPCAbort(ctx context.Context, txn Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.lookup(txn)
+	if t == nil {
+		_ = p.settle("abort", txn, ParticipantAborted)
+		return
+	}
+	p.dm.TPCAbort(ctx, txn)
+	p.complete(t, ParticipantAborted)
+}
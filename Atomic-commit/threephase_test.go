@@ -0,0 +1,354 @@
+package atomiccommit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func (d *dmRecord) TPCPreCommit(_ context.Context, _ Transaction) error {
+	d.rec("precommit")
+	return nil
+}
+
+func TestThreePhaseCommit(t *testing.T) {
+	var log []string
+	txn, ctx := New(WithProtocol(context.Background(), ThreePhase))
+	txn.Join(&dmRecord{name: "a", log: &log})
+	txn.Join(&dmRecord{name: "b", log: &log})
+
+	err := txn.Commit(ctx)
+	if err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+
+	want := []string{
+		"a.begin", "b.begin",
+		"a.commit", "b.commit",
+		"a.vote", "b.vote",
+		"a.precommit", "b.precommit",
+		"a.finish", "b.finish",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("commit:\nhave: %q\nwant: %q", log, want)
+	}
+}
+
+func TestThreePhaseNotPreCommitter(t *testing.T) {
+	txn, ctx := New(WithProtocol(context.Background(), ThreePhase))
+	dm := &dmAbortOnly{t: t, txn: txn}
+	dm.Modify()
+
+	err := txn.Commit(ctx)
+	if err == nil {
+		t.Fatal("three-phase commit with 2PC-only participant: no error")
+	}
+	if !(dm.nabort == 1 && txn.Status() == Aborted) {
+		t.Fatalf("commit: nabort=%d; txn.Status=%v", dm.nabort, txn.Status())
+	}
+}
+
+// crashLink delivers coordinator messages to participant until coordinator
+// crashes just before sending message crashAt.
+type crashLink struct {
+	PreCommitter
+	crashAt string
+
+	mu      sync.Mutex
+	crashed bool
+}
+
+// deliver reports whether message msg reaches the participant.
+func (l *crashLink) deliver(msg string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if msg == l.crashAt {
+		l.crashed = true
+	}
+	return !l.crashed
+}
+
+func (l *crashLink) Abort(txn Transaction) {
+	if l.deliver("abort") {
+		l.PreCommitter.Abort(txn)
+	}
+}
+func (l *crashLink) TPCBegin(txn Transaction) {
+	if l.deliver("begin") {
+		l.PreCommitter.TPCBegin(txn)
+	}
+}
+func (l *crashLink) Commit(ctx context.Context, txn Transaction) error {
+	if l.deliver("commit") {
+		return l.PreCommitter.Commit(ctx, txn)
+	}
+	return nil
+}
+func (l *crashLink) TPCVote(ctx context.Context, txn Transaction) error {
+	if l.deliver("vote") {
+		return l.PreCommitter.TPCVote(ctx, txn)
+	}
+	return nil
+}
+func (l *crashLink) TPCPreCommit(ctx context.Context, txn Transaction) error {
+	if l.deliver("precommit") {
+		return l.PreCommitter.TPCPreCommit(ctx, txn)
+	}
+	return nil
+}
+func (l *crashLink) TPCFinish(ctx context.Context, txn Transaction) error {
+	if l.deliver("finish") {
+		return l.PreCommitter.TPCFinish(ctx, txn)
+	}
+	return nil
+}
+func (l *crashLink) TPCAbort(ctx context.Context, txn Transaction) {
+	if l.deliver("tpcabort") {
+		l.PreCommitter.TPCAbort(ctx, txn)
+	}
+}
+
+func TestThreePhaseCoordinatorCrash(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+
+	// coordinator crash point at every participant -> how participants
+	// complete on their own
+	testv := []struct {
+		name    string
+		crashAt []string
+		outcome string
+	}{
+		{"commit", []string{"commit", "commit"}, "abort"},
+		{"vote", []string{"vote", "vote"}, "abort"},
+		{"precommit", []string{"precommit", "precommit"}, "abort"},
+		{"finish", []string{"finish", "finish"}, "finish"},
+		// only the first participant was pre-committed: the second one
+		// learns from it that the transaction has to commit
+		{"precommit-partial", []string{"finish", "precommit"}, "finish"},
+	}
+
+	for _, tt := range testv {
+		t.Run(tt.name, func(t *testing.T) {
+			var dmv []*dmOutcome
+			var pv []*ThreePhaseParticipant
+			txn, ctx := New(WithProtocol(context.Background(), ThreePhase))
+			for _, crashAt := range tt.crashAt {
+				dm := &dmOutcome{outcome: make(chan string, 1)}
+				dmv = append(dmv, dm)
+				p := NewThreePhaseParticipant(dm, timeout)
+				pv = append(pv, p)
+				txn.Join(&crashLink{PreCommitter: p, crashAt: crashAt})
+			}
+			for i, p := range pv {
+				var peers []ThreePhasePeer
+				for j, peer := range pv {
+					if j != i {
+						peers = append(peers, peer)
+					}
+				}
+				p.SetPeers(peers...)
+			}
+
+			// the coordinator "crashes" - its outcome does not matter
+			_ = txn.Commit(ctx)
+
+			for i, dm := range dmv {
+				select {
+				case outcome := <-dm.outcome:
+					if outcome != tt.outcome {
+						t.Errorf("participant %d: %s;  want %s", i, outcome, tt.outcome)
+					}
+				case <-time.After(50 * timeout):
+					t.Errorf("participant %d: did not complete on its own", i)
+				}
+			}
+		})
+	}
+}
+
+// unreachablePeer is ThreePhasePeer that cannot be asked.
+type unreachablePeer struct{}
+
+func (unreachablePeer) State(string) (ParticipantState, error) {
+	return ParticipantUnknown, errors.New("unreachable")
+}
+func (unreachablePeer) Terminate(string, ParticipantState) error { return errors.New("unreachable") }
+
+func TestThreePhaseVotedBlocks(t *testing.T) {
+	const timeout = 10 * time.Millisecond
+
+	for name, peers := range map[string][]ThreePhasePeer{
+		"no-termination":   nil,
+		"unreachable-peer": {unreachablePeer{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dm := &dmOutcome{outcome: make(chan string, 1)}
+			p := NewThreePhaseParticipant(dm, timeout)
+			if peers != nil {
+				p.SetPeers(peers...)
+			}
+			txn, ctx := New(context.Background())
+			p.TPCBegin(txn)
+			err := p.Commit(ctx, txn)
+			if err == nil {
+				err = p.TPCVote(ctx, txn)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// uncertain participant must wait for somebody who knows
+			select {
+			case outcome := <-dm.outcome:
+				t.Fatalf("voted participant completed on its own: %s", outcome)
+			case <-time.After(10 * timeout):
+			}
+
+			// and completes as told once coordinator comes back
+			err = p.TPCPreCommit(ctx, txn)
+			if err == nil {
+				err = p.TPCFinish(ctx, txn)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome := <-dm.outcome; outcome != "finish" {
+				t.Fatalf("outcome: %s;  want finish", outcome)
+			}
+		})
+	}
+}
+
+func TestThreePhaseLateCoordinator(t *testing.T) {
+	dm := &dmOutcome{outcome: make(chan string, 1)}
+	p := NewThreePhaseParticipant(dm, 10*time.Millisecond)
+	p.SetPeers() // the only participant
+	txn, ctx := New(context.Background())
+
+	p.TPCBegin(txn)
+	err := p.Commit(ctx, txn)
+	if err == nil {
+		err = p.TPCVote(ctx, txn)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// coordinator goes silent; participant aborts on its own
+	if outcome := <-dm.outcome; outcome != "abort" {
+		t.Fatalf("outcome: %s;  want abort", outcome)
+	}
+
+	// coordinator comes back too late
+	err = p.TPCPreCommit(ctx, txn)
+	if err == nil {
+		t.Fatal("pre-commit after timeout: no error")
+	}
+	err = p.TPCFinish(ctx, txn)
+	if err == nil {
+		t.Fatal("finish after abort on timeout: no error")
+	}
+	// the split outcome stays visible
+	if state, _ := p.State(txn.ID()); state != ParticipantAborted {
+		t.Fatalf("state after split: %v;  want aborted", state)
+	}
+}
+
+// preCommit runs txn on p up to pre-commit.
+func preCommit(t *testing.T, p *ThreePhaseParticipant, txn Transaction, ctx context.Context) {
+	t.Helper()
+	p.TPCBegin(txn)
+	err := p.Commit(ctx, txn)
+	if err == nil {
+		err = p.TPCVote(ctx, txn)
+	}
+	if err == nil {
+		err = p.TPCPreCommit(ctx, txn)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThreePhaseLateAbort(t *testing.T) {
+	dm := &dmOutcome{outcome: make(chan string, 1)}
+	p := NewThreePhaseParticipant(dm, 10*time.Millisecond)
+	txn, ctx := New(context.Background())
+	preCommit(t, p, txn, ctx)
+
+	if outcome := <-dm.outcome; outcome != "finish" {
+		t.Fatalf("outcome: %s;  want finish", outcome)
+	}
+
+	// coordinator comes back and aborts: too late, the participant
+	// committed and stays committed
+	p.TPCAbort(ctx, txn)
+	if state, _ := p.State(txn.ID()); state != ParticipantCommitted {
+		t.Fatalf("state after split: %v;  want committed", state)
+	}
+}
+
+// dmFinishFail is dmOutcome whose TPCFinish fails the first nfail times.
+type dmFinishFail struct {
+	dmOutcome
+	mu    sync.Mutex
+	nfail int
+}
+
+func (d *dmFinishFail) TPCFinish(ctx context.Context, txn Transaction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nfail > 0 {
+		d.nfail--
+		return errors.New("finish failed")
+	}
+	return d.dmOutcome.TPCFinish(ctx, txn)
+}
+
+func TestThreePhaseFinishRetry(t *testing.T) {
+	dm := &dmFinishFail{dmOutcome: dmOutcome{outcome: make(chan string, 1)}, nfail: 2}
+	p := NewThreePhaseParticipant(dm, 10*time.Millisecond)
+	txn, ctx := New(context.Background())
+	preCommit(t, p, txn, ctx)
+
+	// finish fails on the first timeouts and is retried until it succeeds
+	select {
+	case outcome := <-dm.outcome:
+		if outcome != "finish" {
+			t.Fatalf("outcome: %s;  want finish", outcome)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed finish was not retried")
+	}
+	if state, _ := p.State(txn.ID()); state != ParticipantCommitted {
+		t.Fatalf("state: %v;  want committed", state)
+	}
+}
+
+func TestThreePhaseOutcomeRetention(t *testing.T) {
+	const timeout = 10 * time.Millisecond
+	dm := &dmOutcome{outcome: make(chan string, 2)}
+	p := NewThreePhaseParticipant(dm, timeout)
+	p.retention = timeout
+
+	txn1, _ := New(context.Background())
+	p.TPCBegin(txn1)
+	<-dm.outcome
+	time.Sleep(2 * timeout)
+
+	// outcome of txn1 is forgotten once it is older than retention
+	txn2, _ := New(context.Background())
+	p.TPCBegin(txn2)
+	<-dm.outcome
+
+	p.mu.Lock()
+	_, ok1 := p.expired[txn1.ID()]
+	_, ok2 := p.expired[txn2.ID()]
+	p.mu.Unlock()
+	if ok1 || !ok2 {
+		t.Fatalf("remembered outcomes: txn1=%v txn2=%v;  want false, true", ok1, ok2)
+	}
+}
@@ -2,7 +2,12 @@ package atomiccommit
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 )
 
 // Package transaction provides transaction management via two-phase commit protocol.
@@ -14,10 +19,22 @@ type transaction struct {
 	datav  []DataManager
 	syncv  []Synchoronizer
 
+	savepointv []*savepoint // savepoints that can still be rolled back to
+
+	doomErr  error       // why transaction was doomed
+	stopDoom func() bool // stops dooming the transaction when its context is done
+
+	id       string      // unique transaction identifier
+	dlog     DecisionLog // where commit decisions are logged; nil if not
+	protocol Protocol    // commit protocol
+
+	created time.Time // when transaction was created
+	audit   *auditor  // audit trail of transaction; nil if not audited
+
 	//metadata
 	user        string
 	description string
-	extention   string
+	extension   map[string]any
 }
 
 type CtxKey struct{}
@@ -42,18 +59,42 @@ func currentTxn(ctx context.Context) Transaction {
 }
 
 // newTxn serves New.
-func newTxn(ctx context.Context) (Transaction, context.Context) {
+func newTxn(ctx context.Context, optv ...Option) (Transaction, context.Context) {
 	if getTxn(ctx) != nil {
-		panic("transactiom : new: nested transaction not supported")
+		panic("transaction: new: nested transactions not supported")
 	}
 
 	txn := &transaction{
-		status: Active,
+		status:   Active,
+		id:       newTxnID(),
+		dlog:     getDecisionLog(ctx),
+		protocol: getProtocol(ctx),
+		created:  time.Now(),
+	}
+	for _, opt := range optv {
+		opt(txn)
+	}
+	if txn.audit != nil {
+		txn.audit.txn = txn
+		txn.syncv = append(txn.syncv, txn.audit)
 	}
+	txn.stopDoom = context.AfterFunc(ctx, func() {
+		txn.doomOnDone(ctx)
+	})
 	txnCtx := context.WithValue(ctx, CtxKey{}, txn)
 	return txn, txnCtx
 }
 
+// newTxnID returns new random transaction identifier.
+func newTxnID() string {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic("transaction: new: cannot generate id: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
 // Status implements Transaction.
 func (txn *transaction) Status() Status {
 	txn.mu.Lock()
@@ -61,12 +102,240 @@ func (txn *transaction) Status() Status {
 	return txn.status
 }
 
-// commit implemts Transaction.
+// Commit implements Transaction.
+//
+// It runs two-phase commit over all joined data managers: TPCBegin, Commit
+// and TPCVote on every participant, then TPCFinish on every participant if
+// all votes succeeded, or Abort/TPCAbort on every participant otherwise.
+//
+// Commit of doomed transaction aborts it and returns error wrapping ErrDoomed.
+// If ctx is done before all participants voted, the transaction is aborted
+// as well.
 func (txn *transaction) Commit(ctx context.Context) error {
-	panic("TODO")
+	var datav []DataManager
+	var syncv []Synchoronizer
+	var doomErr error
+
+	// under lock: change state to commiting; extract datav/syncv
+	func() {
+		txn.mu.Lock()
+		defer txn.mu.Unlock()
+
+		txn.checkNotYetCompleting("commit")
+		if txn.status == Doomed {
+			doomErr = txn.doomErr
+			return
+		}
+		txn.status = Commiting
+
+		datav = txn.datav
+		syncv = txn.syncv
+		txn.savepointv = nil
+	}()
+
+	if doomErr != nil {
+		txn.Abort()
+		return doomErr
+	}
+
+	// lock is released - we can run callbacks
+
+	for _, sync := range syncv {
+		sync.BeforeCompletion(txn)
+	}
+
+	err := txn.checkProtocol(datav)
+	if err == nil {
+		err = txn.logDecision(DecisionPrepare, datav)
+	}
+	if err != nil {
+		txn.setStatus(Aborting)
+		for _, dm := range datav {
+			dm.Abort(txn)
+		}
+		txn.complete(Aborted, syncv)
+		return err
+	}
+
+	voted, err := txn.tpcVote(ctx, datav)
+	if err == nil && txn.protocol == ThreePhase {
+		err = txn.tpcPreCommit(ctx, datav)
+	}
+	if err == nil {
+		err = commitCtxErr(ctx)
+	}
+	if err == nil {
+		// the decision must be durable before any participant is told about it
+		err = txn.logDecision(DecisionCommit, nil)
+	}
+
+	// participants must complete the second phase even if ctx is done
+	ctx = context.WithoutCancel(ctx)
+
+	if err != nil {
+		// logging abort decision is optional: in-doubt transaction without
+		// decision is aborted on recovery anyway.
+		_ = txn.logDecision(DecisionAbort, nil)
+		txn.tpcAbort(ctx, datav, voted)
+		if errEnd := txn.logDecision(DecisionEnd, nil); errEnd != nil {
+			err = errors.Join(err, errEnd)
+		}
+		txn.complete(Aborted, syncv)
+		return err
+	}
+
+	// all participants voted ok - the transaction is committed.
+	// From now on failures can be only reported, not undone.
+	var errv []error
+	for _, dm := range datav {
+		err := dm.TPCFinish(ctx, txn)
+		if err != nil {
+			errv = append(errv, err)
+		}
+	}
+	if len(errv) == 0 {
+		// participants that failed to finish are re-driven on recovery
+		errv = append(errv, txn.logDecision(DecisionEnd, nil))
+	}
+
+	txn.complete(Commited, syncv)
+	return errors.Join(errv...)
+}
+
+// tpcVote runs the first phase of two-phase commit over datav.
+//
+// It returns how many participants voted successfully, and the error that
+// made the vote fail, if any.
+func (txn *transaction) tpcVote(ctx context.Context, datav []DataManager) (voted int, _ error) {
+	for _, dm := range datav {
+		dm.TPCBegin(txn)
+	}
+
+	for _, dm := range datav {
+		err := commitCtxErr(ctx)
+		if err == nil {
+			err = dm.Commit(ctx, txn)
+			if err != nil {
+				// participant that fails to commit votes no
+				txn.auditVote(dm, err)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for i, dm := range datav {
+		err := commitCtxErr(ctx)
+		if err == nil {
+			err = dm.TPCVote(ctx, txn)
+			txn.auditVote(dm, err)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(datav), nil
+}
+
+// commitCtxErr returns error why commit cannot continue under ctx, if ctx is done.
+func commitCtxErr(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("transaction: commit: %w", context.Cause(ctx))
+}
+
+// tpcAbort aborts two-phase commit over datav after the vote failed.
+//
+// the first voted participants already voted successfully; the rest did not
+// and are aborted before the whole two-phase commit is aborted.
+func (txn *transaction) tpcAbort(ctx context.Context, datav []DataManager, voted int) {
+	txn.setStatus(Aborting)
+
+	for _, dm := range datav[voted:] {
+		dm.Abort(txn)
+	}
+	for _, dm := range datav {
+		dm.TPCAbort(ctx, txn)
+	}
 }
 
+// Abort implements Transaction.
+func (txn *transaction) Abort() {
+	var datav []DataManager
+	var syncv []Synchoronizer
+
+	// under lock: change state to aborting; extract datav/syncv
+	func() {
+		txn.mu.Lock()
+		defer txn.mu.Unlock()
+
+		txn.checkNotYetCompleting("abort")
+		txn.status = Aborting
+
+		datav = txn.datav
+		syncv = txn.syncv
+		txn.savepointv = nil
+	}()
+
+	// lock is released - we can run callbacks
+
+	for _, sync := range syncv {
+		sync.BeforeCompletion(txn)
+	}
+
+	for _, dm := range datav {
+		dm.Abort(txn)
+	}
+
+	txn.complete(Aborted, syncv)
+}
 
+// setStatus changes transaction status.
+func (txn *transaction) setStatus(status Status) {
+	txn.mu.Lock()
+	txn.status = status
+	txn.mu.Unlock()
+}
+
+// complete sets final transaction status and notifies syncv about completion.
+func (txn *transaction) complete(status Status, syncv []Synchoronizer) {
+	txn.setStatus(status)
+	if txn.stopDoom != nil {
+		txn.stopDoom()
+	}
+
+	for _, sync := range syncv {
+		sync.AfterCompletion(txn)
+	}
+}
+
+// Doom implements Transaction.
+func (txn *transaction) Doom() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	txn.checkNotYetCompleting("doom")
+	if txn.status == Active {
+		txn.status = Doomed
+		txn.doomErr = ErrDoomed
+	}
+}
+
+// doomOnDone dooms the transaction because its context is done.
+//
+// It is no-op if completion of the transaction already began.
+func (txn *transaction) doomOnDone(ctx context.Context) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.status == Active {
+		txn.status = Doomed
+		txn.doomErr = fmt.Errorf("%w: %w", ErrDoomed, context.Cause(ctx))
+	}
+}
 
 // Join implements Transaction.
 func (txn *transaction) Join(dm DataManager) {
@@ -96,7 +365,7 @@ func (txn *transaction) RegisterSync(sync Synchoronizer) {
 // must be called with .mu held.
 func (txn *transaction) checkNotYetCompleting(who string) {
 	switch txn.status {
-	case Active: // XXX + Doomed ?
+	case Active, Doomed:
 		// ok
 	default:
 		panic("transaction: " + who + ": transaction completion already began")
@@ -105,6 +374,7 @@ func (txn *transaction) checkNotYetCompleting(who string) {
 
 // ---- meta ----
 
-func (txn *transaction) User() string        { return txn.user }
-func (txn *transaction) Description() string { return txn.description }
-func (txn *transaction) Extension() string   { return txn.extention }
+func (txn *transaction) ID() string                { return txn.id }
+func (txn *transaction) User() string              { return txn.user }
+func (txn *transaction) Description() string       { return txn.description }
+func (txn *transaction) Extension() map[string]any { return txn.extension }
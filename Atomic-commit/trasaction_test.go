@@ -2,8 +2,12 @@ package atomiccommit
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestBasic(t *testing.T) {
@@ -98,3 +102,195 @@ func TestAbort(t *testing.T) {
 		txn.Abort()
 	}()
 }
+
+// DataManager that records two-phase commit calls.
+type dmRecord struct {
+	name      string
+	log       *[]string
+	voteErr   error // error to return from TPCVote
+	finishErr error // error to return from TPCFinish
+}
+
+func (d *dmRecord) rec(event string) { *d.log = append(*d.log, d.name+"."+event) }
+
+func (d *dmRecord) Abort(_ Transaction)                            { d.rec("abort") }
+func (d *dmRecord) TPCBegin(_ Transaction)                         { d.rec("begin") }
+func (d *dmRecord) Commit(_ context.Context, _ Transaction) error  { d.rec("commit"); return nil }
+func (d *dmRecord) TPCVote(_ context.Context, _ Transaction) error { d.rec("vote"); return d.voteErr }
+func (d *dmRecord) TPCFinish(_ context.Context, _ Transaction) error {
+	d.rec("finish")
+	return d.finishErr
+}
+func (d *dmRecord) TPCAbort(_ context.Context, _ Transaction) { d.rec("tpcabort") }
+
+// Synchoronizer that records completion notifications and observed status.
+type syncRecord struct {
+	log *[]string
+}
+
+func (s *syncRecord) BeforeCompletion(txn Transaction) {
+	*s.log = append(*s.log, fmt.Sprintf("before(%v)", txn.Status()))
+}
+
+func (s *syncRecord) AfterCompletion(txn Transaction) {
+	*s.log = append(*s.log, fmt.Sprintf("after(%v)", txn.Status()))
+}
+
+func TestCommit(t *testing.T) {
+	var log []string
+	txn, ctx := New(context.Background())
+	txn.RegisterSync(&syncRecord{&log})
+	txn.Join(&dmRecord{name: "a", log: &log})
+	txn.Join(&dmRecord{name: "b", log: &log})
+
+	err := txn.Commit(ctx)
+	if err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	if txn.Status() != Commited {
+		t.Fatalf("commit: txn.Status=%v", txn.Status())
+	}
+
+	want := []string{
+		fmt.Sprintf("before(%v)", Commiting),
+		"a.begin", "b.begin",
+		"a.commit", "b.commit",
+		"a.vote", "b.vote",
+		"a.finish", "b.finish",
+		fmt.Sprintf("after(%v)", Commited),
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("commit:\nhave: %q\nwant: %q", log, want)
+	}
+}
+
+func TestCommitVoteFail(t *testing.T) {
+	var log []string
+	errVote := errors.New("vote failed")
+	txn, ctx := New(context.Background())
+	txn.RegisterSync(&syncRecord{&log})
+	txn.Join(&dmRecord{name: "a", log: &log})
+	txn.Join(&dmRecord{name: "b", log: &log, voteErr: errVote})
+	txn.Join(&dmRecord{name: "c", log: &log})
+
+	err := txn.Commit(ctx)
+	if err != errVote {
+		t.Fatalf("commit: err=%v;  want %v", err, errVote)
+	}
+	if txn.Status() != Aborted {
+		t.Fatalf("commit: txn.Status=%v", txn.Status())
+	}
+
+	want := []string{
+		fmt.Sprintf("before(%v)", Commiting),
+		"a.begin", "b.begin", "c.begin",
+		"a.commit", "b.commit", "c.commit",
+		"a.vote", "b.vote",
+		"b.abort", "c.abort",
+		"a.tpcabort", "b.tpcabort", "c.tpcabort",
+		fmt.Sprintf("after(%v)", Aborted),
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("commit:\nhave: %q\nwant: %q", log, want)
+	}
+}
+
+func TestWith(t *testing.T) {
+	var log []string
+	ok, err := With(context.Background(), func(ctx context.Context) error {
+		Current(ctx).Join(&dmRecord{name: "a", log: &log})
+		return nil
+	})
+	if !(ok && err == nil) {
+		t.Fatalf("with: ok=%v err=%v", ok, err)
+	}
+
+	want := []string{"a.begin", "a.commit", "a.vote", "a.finish"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("with:\nhave: %q\nwant: %q", log, want)
+	}
+}
+
+func TestDoom(t *testing.T) {
+	var log []string
+	txn, ctx := New(context.Background())
+	txn.Join(&dmRecord{name: "a", log: &log})
+
+	txn.Doom()
+	if txn.Status() != Doomed {
+		t.Fatalf("doom: txn.Status=%v", txn.Status())
+	}
+	// doomed transaction can still be joined
+	txn.Join(&dmRecord{name: "b", log: &log})
+
+	err := txn.Commit(ctx)
+	if !errors.Is(err, ErrDoomed) {
+		t.Fatalf("commit doomed: err=%v", err)
+	}
+	if txn.Status() != Aborted {
+		t.Fatalf("commit doomed: txn.Status=%v", txn.Status())
+	}
+	if want := []string{"a.abort", "b.abort"}; !reflect.DeepEqual(log, want) {
+		t.Fatalf("commit doomed:\nhave: %q\nwant: %q", log, want)
+	}
+}
+
+func TestDoomOnDeadline(t *testing.T) {
+	var log []string
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	ok, err := With(ctx, func(ctx context.Context) error {
+		Current(ctx).Join(&dmRecord{name: "a", log: &log})
+		<-ctx.Done()
+		for Current(ctx).Status() != Doomed {
+			time.Sleep(time.Millisecond)
+		}
+		return nil
+	})
+	if ok || !errors.Is(err, ErrDoomed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("with: ok=%v err=%v", ok, err)
+	}
+	if want := []string{"a.abort"}; !reflect.DeepEqual(log, want) {
+		t.Fatalf("with:\nhave: %q\nwant: %q", log, want)
+	}
+}
+
+// dmCancel cancels commit context when its Commit is called.
+type dmCancel struct {
+	dmRecord
+	cancel func()
+}
+
+func (d *dmCancel) Commit(ctx context.Context, txn Transaction) error {
+	d.cancel()
+	return d.dmRecord.Commit(ctx, txn)
+}
+
+func TestCancelDuringCommit(t *testing.T) {
+	var log []string
+	txn, _ := New(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	txn.Join(&dmRecord{name: "a", log: &log})
+	txn.Join(&dmCancel{dmRecord{name: "b", log: &log}, cancel})
+
+	err := txn.Commit(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("commit: err=%v", err)
+	}
+	if txn.Status() != Aborted {
+		t.Fatalf("commit: txn.Status=%v", txn.Status())
+	}
+
+	want := []string{
+		"a.begin", "b.begin",
+		"a.commit", "b.commit",
+		"a.abort", "b.abort",
+		"a.tpcabort", "b.tpcabort",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("commit:\nhave: %q\nwant: %q", log, want)
+	}
+}
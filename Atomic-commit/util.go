@@ -2,6 +2,8 @@ package atomiccommit
 
 import (
 	"context"
+	"errors"
+	"fmt"
 )
 
 type Status int
@@ -12,16 +14,43 @@ const (
 	Commited                //Transaction commit finished successfull
 	Aborting                //Transaction abprt stated
 	Aborted                 //Transaction was aborted by user
+	Doomed                  //Transaction can only be aborted; commit aborts it
 )
 
+var statusStr = map[Status]string{
+	Active:    "active",
+	Commiting: "commiting",
+	Commited:  "commited",
+	Aborting:  "aborting",
+	Aborted:   "aborted",
+	Doomed:    "doomed",
+}
+
+func (s Status) String() string {
+	str, ok := statusStr[s]
+	if !ok {
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+	return str
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ErrDoomed is returned by Commit of a doomed transaction.
+var ErrDoomed = errors.New("transaction: transaction is doomed")
+
 // Transaction represents a transaction.
 // ... and should be completed by user via either Commit or Abort.
 
 type Transaction interface {
+	ID() string          //unique identifier of transaction
 	User() string        //User name associated  with transaction
 	Description() string //description of transaction
 
-	Extention() string
+	Extension() map[string]any //extension metadata of transaction; must not be modified
 
 	Status() Status
 
@@ -31,29 +60,52 @@ type Transaction interface {
 	//abort completes the transaction by executing Abort on All
 	Abort()
 
+	//Doom marks the transaction so that it can only be aborted.
+	//The transaction is also doomed when its context is done.
+	Doom()
+
 	//join associated data managers will participate in the transaction
 	Join(dm DataManager)
 
 	//RegisterSync register sync to be notified of this transaction boundary events
 	RegisterSync(sync Synchoronizer)
+
+	//Savepoint creates savepoint to which modifications can be rolled back
+	Savepoint() (Savepoint, error)
 }
 
 // Datamanger manages data and can transactionally persist it .
 type DataManager interface {
 	//ABort should abort all modification to managed data
+	//
+	//It is called for transactions that are aborted before their commit
+	//began, and for participants that did not vote when commit fails.
 	Abort(txn Transaction)
 
-	//TCPBegin should begin commit of a transaction, starting the two-phase-commit
-	TCPBegin(txn Transaction)
+	//TPCBegin should begin commit of a transaction, starting the two-phase-commit
+	TPCBegin(txn Transaction)
 
-	//Commit should begin commit of a tranaction, starting the two-phase-commit
+	//Commit should commit modifications to managed data.
+	//
+	//It should save changes to be made persistent if the transaction
+	//commits, but must not make them permanent yet.
 	Commit(ctx context.Context, txn Transaction) error
 
-	//TCPVote should verify that a data manager can commit the transaction.
-	TCPVote(ctx context.Context, txn Transaction) error
-
-	//TCPFinish should indicate confirmation that the transaction is done.
-	TCPFinish(cyx context.Context, txn Transaction)
+	//TPCVote should verify that a data manager can commit the transaction.
+	//
+	//After a successful vote the data manager must be able to finish the
+	//commit even if it crashes before TPCFinish is called.
+	TPCVote(ctx context.Context, txn Transaction) error
+
+	//TPCFinish should indicate confirmation that the transaction is done.
+	//
+	//It should make all changes to managed data persistent.
+	TPCFinish(ctx context.Context, txn Transaction) error
+
+	//TPCAbort should abort a transaction whose two-phase-commit already began.
+	//
+	//It should release all resources held for the transaction.
+	TPCAbort(ctx context.Context, txn Transaction)
 }
 
 // Synchromizeer is the interface layer to participate in transaction-boundary notification.
@@ -63,9 +115,45 @@ type Synchoronizer interface {
 	AfterCompletion(txn Transaction)
 }
 
-// func New craete New Transaction
-func New(ctx context.Context) Transaction {
-	return New(ctx)
+// Option configures new transaction.
+type Option func(*transaction)
+
+// WithUser sets name of user associated with transaction.
+func WithUser(user string) Option {
+	return func(txn *transaction) { txn.user = user }
+}
+
+// WithDescription sets description of transaction.
+func WithDescription(description string) Option {
+	return func(txn *transaction) { txn.description = description }
+}
+
+// WithExtension sets extension metadata key of transaction to value.
+//
+// It can be given several times for different keys. Values should be
+// marshallable to JSON to appear in audit trail written by JSONAuditSink.
+func WithExtension(key string, value any) Option {
+	return func(txn *transaction) {
+		if txn.extension == nil {
+			txn.extension = make(map[string]any)
+		}
+		txn.extension[key] = value
+	}
+}
+
+// WithAudit makes transaction record its audit trail to sink.
+//
+// The sink is notified when transaction completion begins, about every vote
+// of participants, and about the outcome of the transaction.
+func WithAudit(sink AuditSink) Option {
+	return func(txn *transaction) { txn.audit = &auditor{sink: sink} }
+}
+
+// New creates new transaction.
+//
+// The transaction is associated with returned context.
+func New(ctx context.Context, optv ...Option) (Transaction, context.Context) {
+	return newTxn(ctx, optv...)
 }
 
 // Current returns current transaction.
@@ -74,12 +162,25 @@ func Current(ctx context.Context) Transaction {
 }
 
 // With runs f in a new transaction, and either commits or aborts it depending on f result.
-func With(ctx context.Context, f func(context.Context) error) (ok bool, _ error) {
-	txn, ctx := newTxn(ctx)
+//
+// ok reports whether the transaction was committed: it is false if f failed,
+// or if Commit failed, e.g. because the transaction was doomed.
+//
+// If ctx already has a transaction, f runs in a nested scope of it instead:
+// modifications made by f are rolled back to a savepoint if f fails, and are
+// committed together with the outer transaction otherwise. optv is ignored
+// in nested scope.
+func With(ctx context.Context, f func(context.Context) error, optv ...Option) (ok bool, _ error) {
+	if txn := getTxn(ctx); txn != nil {
+		return withSavepoint(ctx, txn, f)
+	}
+
+	txn, ctx := newTxn(ctx, optv...)
 	err := f(ctx)
 	if err != nil {
 		txn.Abort() //.err
 		return false, err
 	}
-	return true, txn.Commit(ctx)
+	err = txn.Commit(ctx)
+	return err == nil, err
 }
@@ -0,0 +1,249 @@
+package lowwatermark
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxArchiveBuffer limits length of strings and buffers read from archive, so
+// that corrupt length does not make reader allocate unbounded memory.
+const maxArchiveBuffer = maxEntrySize
+
+// Record is a structure that can be written to OutputArchive and read back
+// from InputArchive.
+type Record interface {
+	Serialize(oa OutputArchive) error
+	Deserialize(ia InputArchive) error
+}
+
+type OutputArchive interface {
+	// Write a byte slice to the archive
+	WriteBytes([]byte) error
+
+	// Write an integer to the archive
+	WriteInt(int) error
+
+	// Write a long integer to the archive
+	WriteLong(int64) error
+
+	// Write a single byte to the archive
+	WriteByte(byte) error
+
+	// Write a boolean to the archive
+	WriteBool(bool) error
+
+	// Write a double precision float to the archive
+	WriteDouble(float64) error
+
+	// Write a string to the archive
+	WriteString(string) error
+
+	// Write a nested record to the archive
+	WriteRecord(Record) error
+
+	// Start a vector of n elements; n is -1 for nil vector
+	StartVector(n int) error
+}
+
+type InputArchive interface {
+	// Read a byte slice from the archive; nil slice reads back as nil
+	ReadBytes() ([]byte, error)
+
+	// Read an integer from the archive
+	ReadInt() (int, error)
+
+	// Read a long integer from the archive
+	ReadLong() (int64, error)
+
+	// Read a single byte from the archive
+	ReadByte() (byte, error)
+
+	// Read a boolean from the archive
+	ReadBool() (bool, error)
+
+	// Read a double precision float from the archive
+	ReadDouble() (float64, error)
+
+	// Read a string from the archive
+	ReadString() (string, error)
+
+	// Read a nested record from the archive
+	ReadRecord(Record) error
+
+	// Start a vector and return number of its elements; -1 for nil vector
+	StartVector() (int, error)
+}
+
+// BinaryOutputArchive is OutputArchive compatible with jute binary encoding
+// used by ZooKeeper.
+//
+// Numbers are big-endian. Strings and byte slices are prefixed with their
+// length as int, with -1 for nil slice. Vectors are prefixed with number of
+// elements as int. Records are written field by field without any framing.
+type BinaryOutputArchive struct {
+	w   io.Writer
+	buf [8]byte
+}
+
+// NewBinaryOutputArchive creates BinaryOutputArchive writing to w.
+func NewBinaryOutputArchive(w io.Writer) *BinaryOutputArchive {
+	return &BinaryOutputArchive{w: w}
+}
+
+func (oa *BinaryOutputArchive) write(b []byte) error {
+	_, err := oa.w.Write(b)
+	return err
+}
+
+func (oa *BinaryOutputArchive) WriteByte(b byte) error {
+	oa.buf[0] = b
+	return oa.write(oa.buf[:1])
+}
+
+func (oa *BinaryOutputArchive) WriteBool(b bool) error {
+	if b {
+		return oa.WriteByte(1)
+	}
+	return oa.WriteByte(0)
+}
+
+func (oa *BinaryOutputArchive) WriteInt(i int) error {
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		return fmt.Errorf("archive: int %d out of range", i)
+	}
+	binary.BigEndian.PutUint32(oa.buf[:4], uint32(int32(i)))
+	return oa.write(oa.buf[:4])
+}
+
+func (oa *BinaryOutputArchive) WriteLong(l int64) error {
+	binary.BigEndian.PutUint64(oa.buf[:8], uint64(l))
+	return oa.write(oa.buf[:8])
+}
+
+func (oa *BinaryOutputArchive) WriteDouble(d float64) error {
+	return oa.WriteLong(int64(math.Float64bits(d)))
+}
+
+func (oa *BinaryOutputArchive) WriteString(s string) error {
+	err := oa.WriteInt(len(s))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(oa.w, s)
+	return err
+}
+
+func (oa *BinaryOutputArchive) WriteBytes(b []byte) error {
+	if b == nil {
+		return oa.WriteInt(-1)
+	}
+	err := oa.WriteInt(len(b))
+	if err != nil {
+		return err
+	}
+	return oa.write(b)
+}
+
+func (oa *BinaryOutputArchive) WriteRecord(r Record) error {
+	return r.Serialize(oa)
+}
+
+func (oa *BinaryOutputArchive) StartVector(n int) error {
+	return oa.WriteInt(n)
+}
+
+// BinaryInputArchive is InputArchive reading what BinaryOutputArchive wrote.
+type BinaryInputArchive struct {
+	r   io.Reader
+	buf [8]byte
+}
+
+// NewBinaryInputArchive creates BinaryInputArchive reading from r.
+func NewBinaryInputArchive(r io.Reader) *BinaryInputArchive {
+	return &BinaryInputArchive{r: r}
+}
+
+func (ia *BinaryInputArchive) read(n int) ([]byte, error) {
+	_, err := io.ReadFull(ia.r, ia.buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	return ia.buf[:n], nil
+}
+
+func (ia *BinaryInputArchive) ReadByte() (byte, error) {
+	b, err := ia.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (ia *BinaryInputArchive) ReadBool() (bool, error) {
+	b, err := ia.ReadByte()
+	return b != 0, err
+}
+
+func (ia *BinaryInputArchive) ReadInt() (int, error) {
+	b, err := ia.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(int32(binary.BigEndian.Uint32(b))), nil
+}
+
+func (ia *BinaryInputArchive) ReadLong() (int64, error) {
+	b, err := ia.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (ia *BinaryInputArchive) ReadDouble() (float64, error) {
+	l, err := ia.ReadLong()
+	return math.Float64frombits(uint64(l)), err
+}
+
+// readLength reads length prefix of a string, buffer or vector.
+func (ia *BinaryInputArchive) readLength() (int, error) {
+	n, err := ia.ReadInt()
+	if err != nil {
+		return 0, err
+	}
+	if n < -1 || n > maxArchiveBuffer {
+		return 0, fmt.Errorf("archive: unreasonable length %d", n)
+	}
+	return n, nil
+}
+
+func (ia *BinaryInputArchive) ReadString() (string, error) {
+	b, err := ia.ReadBytes()
+	return string(b), err
+}
+
+func (ia *BinaryInputArchive) ReadBytes() ([]byte, error) {
+	n, err := ia.readLength()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(ia.r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (ia *BinaryInputArchive) ReadRecord(r Record) error {
+	return r.Deserialize(ia)
+}
+
+func (ia *BinaryInputArchive) StartVector() (int, error) {
+	return ia.readLength()
+}
@@ -0,0 +1,223 @@
+package lowwatermark
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// testACL and testCreateTxn mimic jute records with nested records and vectors.
+type testACL struct {
+	Perms  int
+	Scheme string
+	ID     string
+}
+
+func (a *testACL) Serialize(oa OutputArchive) error {
+	if err := oa.WriteInt(a.Perms); err != nil {
+		return err
+	}
+	if err := oa.WriteString(a.Scheme); err != nil {
+		return err
+	}
+	return oa.WriteString(a.ID)
+}
+
+func (a *testACL) Deserialize(ia InputArchive) (err error) {
+	if a.Perms, err = ia.ReadInt(); err != nil {
+		return err
+	}
+	if a.Scheme, err = ia.ReadString(); err != nil {
+		return err
+	}
+	a.ID, err = ia.ReadString()
+	return err
+}
+
+type testCreateTxn struct {
+	Path      string
+	Data      []byte
+	ACL       []testACL
+	Ephemeral bool
+	Weight    float64
+	Flags     byte
+}
+
+func (c *testCreateTxn) Serialize(oa OutputArchive) error {
+	if err := oa.WriteString(c.Path); err != nil {
+		return err
+	}
+	if err := oa.WriteBytes(c.Data); err != nil {
+		return err
+	}
+	n := len(c.ACL)
+	if c.ACL == nil {
+		n = -1
+	}
+	if err := oa.StartVector(n); err != nil {
+		return err
+	}
+	for i := range c.ACL {
+		if err := oa.WriteRecord(&c.ACL[i]); err != nil {
+			return err
+		}
+	}
+	if err := oa.WriteBool(c.Ephemeral); err != nil {
+		return err
+	}
+	if err := oa.WriteDouble(c.Weight); err != nil {
+		return err
+	}
+	return oa.WriteByte(c.Flags)
+}
+
+func (c *testCreateTxn) Deserialize(ia InputArchive) (err error) {
+	if c.Path, err = ia.ReadString(); err != nil {
+		return err
+	}
+	if c.Data, err = ia.ReadBytes(); err != nil {
+		return err
+	}
+	n, err := ia.StartVector()
+	if err != nil {
+		return err
+	}
+	c.ACL = nil
+	if n >= 0 {
+		c.ACL = make([]testACL, n)
+	}
+	for i := range c.ACL {
+		if err = ia.ReadRecord(&c.ACL[i]); err != nil {
+			return err
+		}
+	}
+	if c.Ephemeral, err = ia.ReadBool(); err != nil {
+		return err
+	}
+	if c.Weight, err = ia.ReadDouble(); err != nil {
+		return err
+	}
+	c.Flags, err = ia.ReadByte()
+	return err
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	tests := []*testCreateTxn{
+		{},
+		{Path: "/a", Data: []byte{}, ACL: []testACL{}},
+		{
+			Path:      "/zk/ηλ",
+			Data:      []byte("data\x00\xff"),
+			ACL:       []testACL{{31, "world", "anyone"}, {1, "digest", "u:p"}},
+			Ephemeral: true,
+			Weight:    -2.5,
+			Flags:     0x80,
+		},
+	}
+	for _, want := range tests {
+		var buf bytes.Buffer
+		err := NewBinaryOutputArchive(&buf).WriteRecord(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &testCreateTxn{}
+		ia := NewBinaryInputArchive(&buf)
+		err = ia.ReadRecord(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("round trip: got %+v; want %+v", got, want)
+		}
+		if _, err := ia.ReadByte(); err != io.EOF {
+			t.Errorf("round trip %+v: trailing data", want)
+		}
+	}
+}
+
+// TestArchiveEncoding verifies byte layout against jute binary encoding.
+func TestArchiveEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	oa := NewBinaryOutputArchive(&buf)
+	for _, err := range []error{
+		oa.WriteInt(-2),
+		oa.WriteLong(0x0102030405060708),
+		oa.WriteString("ab"),
+		oa.WriteBytes(nil),
+		oa.WriteBool(true),
+		oa.StartVector(3),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []byte{
+		0xff, 0xff, 0xff, 0xfe,
+		1, 2, 3, 4, 5, 6, 7, 8,
+		0, 0, 0, 2, 'a', 'b',
+		0xff, 0xff, 0xff, 0xff,
+		1,
+		0, 0, 0, 3,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("encoding:\nhave % x\nwant % x", buf.Bytes(), want)
+	}
+
+	if err := oa.WriteInt(1 << 40); err == nil {
+		t.Fatal("int out of int32 range was written")
+	}
+	ia := NewBinaryInputArchive(bytes.NewReader([]byte{0x7f, 0xff, 0xff, 0xff}))
+	if _, err := ia.ReadBytes(); err == nil {
+		t.Fatal("unreasonable buffer length was accepted")
+	}
+}
+
+func TestTxnHeaderLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	hdr := &TxnHeader{ClientId: 0x1234, Cxid: 5, Zxid: 0x100000001, Time: 1700000000123, Type: 1}
+	txn := &testCreateTxn{Path: "/a", Data: []byte("x"), ACL: []testACL{{31, "world", "anyone"}}}
+	err = l.AppendTxn(hdr, txn)
+	if err == nil {
+		err = l.Sync()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.AppendTxn(hdr, nil); err == nil {
+		t.Fatal("transaction with already logged zxid was appended")
+	}
+	gap := *hdr
+	gap.Zxid += 2
+	if err := l.AppendTxn(&gap, nil); err == nil {
+		t.Fatal("transaction after gap in zxids was appended")
+	}
+	noTime := *hdr
+	noTime.Zxid++
+	noTime.Time = 0
+	if err := l.AppendTxn(&noTime, nil); err == nil {
+		t.Fatal("transaction without time was appended")
+	}
+
+	entries := readAll(t, dir, 7, 0)
+	if len(entries) != 1 || entries[0].Zxid != hdr.Zxid || entries[0].Time.UnixMilli() != hdr.Time {
+		t.Fatalf("read %+v", entries)
+	}
+	gotTxn := &testCreateTxn{}
+	gotHdr, err := ReadTxn(&entries[0], gotTxn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotHdr, hdr) {
+		t.Errorf("header: got %+v; want %+v", gotHdr, hdr)
+	}
+	if !reflect.DeepEqual(gotTxn, txn) {
+		t.Errorf("txn: got %+v; want %+v", gotTxn, txn)
+	}
+}
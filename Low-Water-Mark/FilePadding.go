@@ -0,0 +1,68 @@
+package lowwatermark
+
+import "os"
+
+// paddingThreshold is how close to the end of preallocated space writes may
+// come before more space is preallocated.
+const paddingThreshold = 4096
+
+// FilePadding preallocates space for a log file ahead of writes.
+//
+// Appending into already allocated space does not change file size, so fsync
+// does not have to update file metadata and is faster. The preallocated tail
+// of the file is zero-filled; readers treat zeros as end of the log.
+type FilePadding struct {
+	preallocSize int64
+	currentSize  int64 // size of the file including preallocated space
+}
+
+// SetPreallocSize sets how much space to preallocate at a time.
+func (fp *FilePadding) SetPreallocSize(size int64) {
+	fp.preallocSize = size
+}
+
+// SetCurrentSize tells FilePadding the current size of the file.
+func (fp *FilePadding) SetCurrentSize(size int64) {
+	fp.currentSize = size
+}
+
+// PadFile preallocates more space in f if position comes close to the end
+// of the file, and returns resulting file size.
+func (fp *FilePadding) PadFile(f *os.File, position int64) (int64, error) {
+	newSize := CalculateFileSizeWithPadding(position, fp.currentSize, fp.preallocSize)
+	if newSize != fp.currentSize {
+		err := preallocate(f, fp.currentSize, newSize-fp.currentSize)
+		if err != nil {
+			return fp.currentSize, err
+		}
+		fp.currentSize = newSize
+	}
+	return fp.currentSize, nil
+}
+
+// CalculateFileSizeWithPadding returns size a file should be extended to
+// when writing at position into file of fileSize with preAllocSize.
+//
+// The file is extended only if position is within paddingThreshold of
+// fileSize.
+func CalculateFileSizeWithPadding(position, fileSize, preAllocSize int64) int64 {
+	if preAllocSize > 0 && position+paddingThreshold >= fileSize {
+		if position > fileSize {
+			// written past what was previously preallocated: make
+			// sure the new size is beyond position
+			fileSize = position + preAllocSize
+			fileSize -= fileSize % preAllocSize
+		} else {
+			fileSize += preAllocSize
+		}
+	}
+	return fileSize
+}
+
+// zeroFill extends f to offset+length by writing a zero byte at its end.
+//
+// Space in between reads back as zeros.
+func zeroFill(f *os.File, offset, length int64) error {
+	_, err := f.WriteAt([]byte{0}, offset+length-1)
+	return err
+}
@@ -0,0 +1,21 @@
+package lowwatermark
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocate allocates length bytes of f starting at offset.
+//
+// fallocate is used if the filesystem supports it.
+func preallocate(f *os.File, offset, length int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, offset, length)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return zeroFill(f, offset, length)
+	}
+	if err != nil {
+		return &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package lowwatermark
+
+import "os"
+
+// preallocate allocates length bytes of f starting at offset.
+func preallocate(f *os.File, offset, length int64) error {
+	return zeroFill(f, offset, length)
+}
@@ -0,0 +1,69 @@
+package lowwatermark
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCalculateFileSizeWithPadding(t *testing.T) {
+	const prealloc = 64 * 1024
+	tests := []struct {
+		position, fileSize, want int64
+	}{
+		{0, 0, prealloc},                           // empty file is padded
+		{100, prealloc, prealloc},                  // far from the end
+		{prealloc - 100, prealloc, 2 * prealloc},   // within threshold of the end
+		{prealloc + 100, prealloc, 2 * prealloc},   // written past the end
+		{3*prealloc + 100, prealloc, 4 * prealloc}, // written far past the end
+		{prealloc - paddingThreshold, prealloc, 2 * prealloc},
+	}
+	for _, tt := range tests {
+		got := CalculateFileSizeWithPadding(tt.position, tt.fileSize, prealloc)
+		if got != tt.want {
+			t.Errorf("CalculateFileSizeWithPadding(%d, %d, %d) = %d; want %d",
+				tt.position, tt.fileSize, prealloc, got, tt.want)
+		}
+	}
+
+	if got := CalculateFileSizeWithPadding(100, 0, 0); got != 0 {
+		t.Errorf("no preallocation: size = %d; want 0", got)
+	}
+}
+
+func TestFileTxnLogPadding(t *testing.T) {
+	defer SetPreallocSize(filePadding.preallocSize)
+	SetPreallocSize(64 * 1024)
+
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 3)
+	if l.fileSize != 64*1024 {
+		t.Fatalf("file size = %d; want %d", l.fileSize, 64*1024)
+	}
+	info, err := os.Stat(l.logFileWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != l.fileSize {
+		t.Fatalf("size on disk = %d; want %d", info.Size(), l.fileSize)
+	}
+	if got := readAll(t, dir, 7, 0); len(got) != 3 {
+		t.Fatalf("padded log: read %d entries; want 3", len(got))
+	}
+	l.Close()
+
+	// appends after reopen continue right after the last entry
+	l, err = OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendN(t, l, 2)
+	got := readAll(t, dir, 7, 0)
+	if len(got) != 5 || got[4].Zxid != 5 {
+		t.Fatalf("after reopen: read %+v", got)
+	}
+}
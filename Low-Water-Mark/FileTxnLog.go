@@ -2,12 +2,17 @@ package lowwatermark
 
 import (
 	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,15 +22,32 @@ const (
 	FSYNC_WARNING_THRESHOLD_MS_PROPERTY           = "fsync.warningthresholdms"
 	ZOOKEEPER_FSYNC_WARNING_THRESHOLD_MS_PROPERTY = "zookeeper." + FSYNC_WARNING_THRESHOLD_MS_PROPERTY
 	txnLogSizeLimitSetting                        = "zookeeper.txnLogSizeLimitInKb"
+	FORCE_SYNC_PROPERTY                           = "zookeeper.forceSync"
+	PREALLOC_SIZE_PROPERTY                        = "zookeeper.preAllocSize"
+)
+
+const (
+	fileHeaderSize  = 16       // magic(4) version(4) dbId(8)
+	entryHeaderSize = 24       // crc(4) length(4) zxid(8) time(8)
+	maxEntrySize    = 64 << 20 // entries larger than this are treated as corrupt
+)
+
+var (
+	// ErrCorrupt is returned when log data does not match its checksum.
+	ErrCorrupt = errors.New("txnlog: corrupt entry")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
 )
 
 var (
 	fsyncWarningThresholdMS int64
 	txnLogSizeLimit         int64
-	filePadding             = FilePadding{} // struct for file padding functionality (implementation needed)
+	forceSync               = true
+	filePadding             = FilePadding{preallocSize: 64 << 20} // preallocation settings every new log starts with
 )
 
 type FileTxnLog struct {
+	mu                   sync.Mutex
 	lastZxidSeen         int64
 	logStream            *bufio.Writer // replaces BufferedOutputStream
 	oa                   OutputArchive // type definition needed based on your implementation
@@ -34,13 +56,27 @@ type FileTxnLog struct {
 	forceSync            bool
 	dbId                 int64
 	streamsToFlush       chan *os.File // channel for file streams to flush
-	logFileWrite         *os.File      // current log file being written to
-	fileSize             int64
+	logFileWrite         string        // path of current log file being written to
+	fileSize             int64         // size of current segment including preallocated tail
+	padding              FilePadding
 	unFlushedSize        int64
-	filePosition         int64
+	filePosition         int64 // end of entries in current segment
 	prevLogsRunningTotal int64
-	serverStats          *ServerStats // type definition needed
+	serverStats          ServerStats // receives append, fsync and batch statistics; may be nil
 	syncElapsedMS        int64
+	lowWaterMark         int64            // zxid of the newest snapshot or of the last deleted entry
+	appendedBytes        int64            // bytes of entries appended since the log was opened
+	now                  func() time.Time // clock for entry timestamps
+	group                *groupCommit
+	repaired             bool // whether a torn write was truncated on open
+	closed               bool
+}
+
+// TxnEntry is a transaction entry read back from the log.
+type TxnEntry struct {
+	Zxid int64
+	Time time.Time // when the entry was appended, with millisecond precision
+	Data []byte
 }
 
 type ServerStats interface {
@@ -52,34 +88,22 @@ type ServerStats interface {
 
 	// Get the average latency of requests
 	GetAvgLatency() float64
-}
-
-type OutputArchive interface {
-	// Write a byte slice to the archive
-	WriteBytes([]byte) error
 
-	// Write an integer to the archive
-	WriteInt(int) error
+	// Report that fsync of the log took elapsed time
+	UpdateFsyncTime(elapsed time.Duration)
 
-	// Write a long integer to the archive
-	WriteLong(int64) error
-}
+	// Report that one group commit made entries of total bytes durable
+	UpdateBatchSize(entries int, bytes int64)
 
-type FilePadding struct { // Needs implementation based on your padding logic
-	preallocSize int64
+	// Report that an entry taking bytes on disk was appended to the log
+	UpdateAppend(bytes int64)
 }
 
-// type TxnLog struct{
-// 	sessioned [8]byte
-// 	cxId [4]byte
-// 	ZxId [8]byte
-// 	time time.Time
-// 	Type [4]byte
-// }
-
 type TxnLog interface {
 	// Append a transaction entry to the log
 	AppendEntry(txn []byte) error
+	// Read entries back starting from zxid
+	Read(zxid int64) (*TxnIterator, error)
 	// Close the transaction log and release resources
 	Close() error
 	//Synchronize the log data to disk
@@ -113,10 +137,445 @@ func init() {
 			log.Printf("%s=%d", txnLogSizeLimitSetting, txnLogSizeLimit)
 		}
 	}
+
+	// Read preAllocSize property
+	if sizeStr := os.Getenv(PREALLOC_SIZE_PROPERTY); sizeStr != "" {
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil {
+			log.Printf("Error parsing %s: %v", PREALLOC_SIZE_PROPERTY, err)
+		} else {
+			filePadding.SetPreallocSize(size * 1024) // convert KB to bytes
+		}
+	}
+
+	// Read forceSync property
+	if os.Getenv(FORCE_SYNC_PROPERTY) == "no" {
+		forceSync = false
+	}
+}
+
+// SetTxnLogSizeLimit sets size in bytes after which log rolls over to a new
+// segment. Zero or negative size disables rollover.
+func SetTxnLogSizeLimit(size int64) {
+	txnLogSizeLimit = size
+}
+
+// SetPreallocSize sets how much space, in bytes, new logs preallocate at a
+// time. Zero or negative size disables preallocation.
+func SetPreallocSize(size int64) {
+	filePadding.SetPreallocSize(size)
+}
+
+// OpenFileTxnLog opens transaction log kept in logDir, creating the directory
+// if it does not exist.
+//
+// Appends continue the newest log file found in logDir. A partially written
+// entry at the end of that file, left by a crash, is truncated away.
+func OpenFileTxnLog(logDir string, dbId int64) (*FileTxnLog, error) {
+	err := os.MkdirAll(logDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileTxnLog{
+		logDir:         logDir,
+		dbId:           dbId,
+		forceSync:      forceSync,
+		now:            time.Now,
+		padding:        FilePadding{preallocSize: filePadding.preallocSize},
+		streamsToFlush: make(chan *os.File, 16),
+	}
+
+	segv, err := ListSegments(logDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segv[:max(len(segv)-1, 0)] {
+		f.prevLogsRunningTotal += seg.Size
+	}
+	if len(segv) > 0 {
+		err = f.reopen(segv[len(segv)-1])
+		if err != nil {
+			return nil, fmt.Errorf("txnlog: open %s: %w", logDir, err)
+		}
+	}
+	if len(segv) > 0 {
+		// segments before the first one were deleted by retention
+		f.lowWaterMark = segv[0].Zxid - 1
+	}
+
+	snapv, err := ListSnapshots(logDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapv) > 0 {
+		f.lowWaterMark = max(f.lowWaterMark, snapv[len(snapv)-1].Zxid)
+		if f.lastZxidSeen < f.lowWaterMark {
+			// log was truncated up to the snapshot
+			f.lastZxidSeen = f.lowWaterMark
+		}
+	}
+	f.group = newGroupCommit(f.lastZxidSeen)
+	return f, nil
+}
+
+// reopen continues appending to existing segment seg.
+//
+// A torn write at the end of the segment is truncated back to the last valid
+// entry. Corruption before that is an error.
+func (f *FileTxnLog) reopen(seg Segment) error {
+	f.lastZxidSeen = seg.Zxid - 1
+	if seg.Size < fileHeaderSize {
+		// crashed before the header was written; the file is
+		// created anew on next append
+		return os.Remove(seg.Path)
+	}
+
+	rep := ScanSegment(seg)
+	if rep.Err != nil {
+		return rep.Err
+	}
+	if rep.DbId != f.dbId {
+		return fmt.Errorf("%s: header: dbId %d, expected %d", seg.Path, rep.DbId, f.dbId)
+	}
+	if rep.Entries > 0 {
+		f.lastZxidSeen = rep.LastZxid
+	}
+	end := rep.ValidSize
+	if rep.Torn {
+		log.Printf("txnlog: %s: truncating torn write at offset %d after zxid %#x (%d bytes)",
+			seg.Path, end, f.lastZxidSeen, seg.Size-end)
+	}
+
+	// drop torn tail and preallocated space; space is preallocated again
+	// on next append
+	fos, err := os.OpenFile(seg.Path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = fos.Truncate(end)
+	if err == nil {
+		_, err = fos.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		fos.Close()
+		return err
+	}
+
+	f.fos = fos
+	f.logStream = bufio.NewWriter(fos)
+	f.logFileWrite = seg.Path
+	f.filePosition = end
+	f.fileSize = end
+	f.padding.SetCurrentSize(end)
+	f.repaired = rep.Torn
+	return nil
+}
+
+// AppendEntry appends txn to the log under next zxid.
+//
+// The entry is buffered; it is durable only after Sync.
+func (f *FileTxnLog) AppendEntry(txn []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.appendEntry(f.lastZxidSeen+1, f.now(), txn)
+}
+
+// appendEntry writes entry with zxid logged at time t, starting new log file
+// if none is open.
+//
+// must be called with .mu held.
+func (f *FileTxnLog) appendEntry(zxid int64, t time.Time, txn []byte) error {
+	if f.closed {
+		return errors.New("txnlog: append: log is closed")
+	}
+	if zxid <= f.lastZxidSeen {
+		return fmt.Errorf("txnlog: append: zxid %#x is not after last zxid %#x", zxid, f.lastZxidSeen)
+	}
+	if len(txn) > maxEntrySize {
+		return fmt.Errorf("txnlog: append: entry of %d bytes is too large", len(txn))
+	}
+
+	if f.logStream != nil && txnLogSizeLimit > 0 && f.filePosition >= txnLogSizeLimit {
+		err := f.rollLog()
+		if err != nil {
+			return fmt.Errorf("txnlog: append: %w", err)
+		}
+	}
+	if f.logStream == nil {
+		err := f.create(zxid)
+		if err != nil {
+			return fmt.Errorf("txnlog: append: %w", err)
+		}
+	}
+
+	var hdr [entryHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[4:], uint32(len(txn)))
+	binary.BigEndian.PutUint64(hdr[8:], uint64(zxid))
+	binary.BigEndian.PutUint64(hdr[16:], uint64(t.UnixMilli()))
+	crc := crc32.Update(0, crcTable, hdr[8:])
+	crc = crc32.Update(crc, crcTable, txn)
+	binary.BigEndian.PutUint32(hdr[0:], crc)
+
+	n := int64(entryHeaderSize + len(txn))
+	fileSize, err := f.padding.PadFile(f.fos, f.filePosition+n)
+	if err != nil {
+		return fmt.Errorf("txnlog: append: %w", err)
+	}
+	f.fileSize = fileSize
+
+	_, err = f.logStream.Write(hdr[:])
+	if err == nil {
+		_, err = f.logStream.Write(txn)
+	}
+	if err != nil {
+		return fmt.Errorf("txnlog: append: %w", err)
+	}
+
+	f.filePosition += n
+	f.unFlushedSize += n
+	if f.filePosition > f.fileSize {
+		f.fileSize = f.filePosition
+	}
+	f.lastZxidSeen = zxid
+	f.appendedBytes += n
+	if f.serverStats != nil {
+		f.serverStats.UpdateAppend(n)
+	}
+	return nil
+}
+
+// create starts new log file whose first entry has zxid.
+//
+// must be called with .mu held.
+func (f *FileTxnLog) create(zxid int64) error {
+	path := filepath.Join(f.logDir, logFileName(zxid))
+	fos, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	logStream := bufio.NewWriter(fos)
+	var hdr [fileHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:], TXNLOG_MAGIC)
+	binary.BigEndian.PutUint32(hdr[4:], VERSION)
+	binary.BigEndian.PutUint64(hdr[8:], uint64(f.dbId))
+	_, err = logStream.Write(hdr[:])
+	if err != nil {
+		fos.Close()
+		return err
+	}
+
+	f.fos = fos
+	f.logStream = logStream
+	f.logFileWrite = path
+	f.filePosition = fileHeaderSize
+	f.fileSize = fileHeaderSize
+	f.padding.SetCurrentSize(0)
+	f.unFlushedSize += fileHeaderSize
+	return nil
+}
+
+// RollLog closes current segment, so that next append starts a new one.
+func (f *FileTxnLog) RollLog() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.logStream == nil {
+		return nil
+	}
+	err := f.rollLog()
+	if err != nil {
+		return fmt.Errorf("txnlog: roll: %w", err)
+	}
+	return nil
+}
+
+// rollLog flushes current segment and hands it to streamsToFlush to be
+// synced and closed by next Sync.
+//
+// must be called with .mu held.
+func (f *FileTxnLog) rollLog() error {
+	err := f.logStream.Flush()
+	if err != nil {
+		return err
+	}
+
+	// finished segments do not need preallocated space anymore
+	err = f.fos.Truncate(f.filePosition)
+	if err != nil {
+		return err
+	}
+
+	fos := f.fos
+	f.prevLogsRunningTotal += f.filePosition
+	f.logStream = nil
+	f.fos = nil
+	f.logFileWrite = ""
+	f.filePosition = 0
+	f.fileSize = 0
+
+	select {
+	case f.streamsToFlush <- fos:
+	default:
+		// too many segments are waiting for sync
+		return f.finishStream(fos)
+	}
+	return nil
+}
+
+// finishStream syncs, unless forceSync is off, and closes finished segment fos.
+func (f *FileTxnLog) finishStream(fos *os.File) error {
+	var err error
+	if f.forceSync {
+		err = fos.Sync()
+	}
+	if errClose := fos.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// flushStreams finishes all segments waiting in streamsToFlush.
+//
+// must be called with .mu held.
+func (f *FileTxnLog) flushStreams() error {
+	var errs []error
+	for {
+		select {
+		case fos := <-f.streamsToFlush:
+			err := f.finishStream(fos)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			return errors.Join(errs...)
+		}
+	}
 }
 
-func (fp *FilePadding) SetPreallocSize(size int64) {
-	fp.preallocSize = size
+// Sync flushes buffered entries and, unless forceSync is off, fsyncs them to
+// disk.
+//
+// Segments finished by rollover are synced first. A warning is logged if
+// fsync of current segment takes longer than fsyncWarningThresholdMS.
+func (f *FileTxnLog) Sync() error {
+	_, _, err := f.sync()
+	return err
+}
+
+// sync implements Sync.
+//
+// It returns zxid of the last entry and total appended bytes at the time of
+// sync; the log is durable up to them if there is no error.
+func (f *FileTxnLog) sync() (zxid, appended int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	zxid, appended = f.lastZxidSeen, f.appendedBytes
+	err = f.flushStreams()
+	if err != nil {
+		return zxid, appended, fmt.Errorf("txnlog: sync: %w", err)
+	}
+	if f.logStream == nil {
+		return zxid, appended, nil
+	}
+	err = f.logStream.Flush()
+	if err != nil {
+		return zxid, appended, fmt.Errorf("txnlog: sync: %w", err)
+	}
+	f.unFlushedSize = 0
+
+	if !f.forceSync {
+		return zxid, appended, nil
+	}
+	start := time.Now()
+	err = f.fos.Sync()
+	if err != nil {
+		return zxid, appended, fmt.Errorf("txnlog: sync: %w", err)
+	}
+	elapsed := time.Since(start)
+	f.syncElapsedMS = elapsed.Milliseconds()
+	if f.syncElapsedMS > fsyncWarningThresholdMS {
+		log.Printf("txnlog: fsyncing %s took %dms which will adversely effect operation latency (threshold %dms)",
+			f.logFileWrite, f.syncElapsedMS, fsyncWarningThresholdMS)
+	}
+	if f.serverStats != nil {
+		f.serverStats.UpdateFsyncTime(elapsed)
+	}
+	return zxid, appended, nil
+}
+
+// SetServerStats sets where the log reports its statistics.
+func (f *FileTxnLog) SetServerStats(stats ServerStats) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.serverStats = stats
+}
+
+// Repaired tells whether a torn write at the end of the log was truncated
+// when the log was opened.
+func (f *FileTxnLog) Repaired() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.repaired
+}
+
+// GetLastLoggedZxid returns zxid of the last entry appended to the log.
+func (f *FileTxnLog) GetLastLoggedZxid() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastZxidSeen
+}
+
+// GetCurrentLogSize returns size of entries in current segment.
+//
+// Space preallocated at the end of the segment is not counted.
+func (f *FileTxnLog) GetCurrentLogSize() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.filePosition
+}
+
+// GetTotalLogSize returns size of all segments of the log.
+//
+// Space preallocated at the end of current segment is not counted.
+func (f *FileTxnLog) GetTotalLogSize() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.prevLogsRunningTotal + f.filePosition
+}
+
+// GetSyncElapsedMS returns how long the last fsync took.
+func (f *FileTxnLog) GetSyncElapsedMS() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.syncElapsedMS
+}
+
+// Read returns iterator over entries starting from zxid.
+//
+// Buffered entries are flushed first, so that they are seen by the iterator.
+func (f *FileTxnLog) Read(zxid int64) (*TxnIterator, error) {
+	f.mu.Lock()
+	if f.logStream != nil {
+		err := f.logStream.Flush()
+		if err != nil {
+			f.mu.Unlock()
+			return nil, fmt.Errorf("txnlog: read: %w", err)
+		}
+		f.unFlushedSize = 0
+	}
+	f.mu.Unlock()
+
+	return OpenTxnIterator(f.logDir, f.dbId, zxid)
 }
 
 // Interface implementations (assuming TxnLog and io.Closer are defined elsewhere)
@@ -133,6 +592,17 @@ func (f *FileTxnLog) Implement(i interface{}) error {
 }
 
 func (f *FileTxnLog) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.streamsToFlush != nil {
+		close(f.streamsToFlush)
+	}
+
 	var errs []error
 	if f.logStream != nil {
 		err := f.logStream.Flush()
@@ -146,10 +616,12 @@ func (f *FileTxnLog) Close() error {
 			errs = append(errs, err)
 		}
 	}
-	for f := range f.streamsToFlush {
-		err := f.Close()
-		if err != nil {
-			errs = append(errs, err)
+	if f.streamsToFlush != nil {
+		for fos := range f.streamsToFlush {
+			err := f.finishStream(fos)
+			if err != nil {
+				errs = append(errs, err)
+			}
 		}
 	}
 	if len(errs) > 0 {
@@ -0,0 +1,279 @@
+package lowwatermark
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// appendN appends entries "txn 1" ... "txn n" to l and syncs it.
+func appendN(t *testing.T, l *FileTxnLog, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		err := l.AppendEntry([]byte(fmt.Sprintf("txn %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readAll returns entries of log in dir starting from zxid.
+func readAll(t *testing.T, dir string, dbId, zxid int64) []TxnEntry {
+	t.Helper()
+	it, err := OpenTxnIterator(dir, dbId, zxid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	var entries []TxnEntry
+	for {
+		e, err := it.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, *e)
+	}
+}
+
+func TestFileTxnLogAppendRead(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	appendN(t, l, 5)
+	if zxid := l.GetLastLoggedZxid(); zxid != 5 {
+		t.Fatalf("last zxid = %d; want 5", zxid)
+	}
+
+	it, err := l.Read(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	for zxid := int64(3); zxid <= 5; zxid++ {
+		e, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := TxnEntry{Zxid: zxid, Data: []byte(fmt.Sprintf("txn %d", zxid))}
+		if e.Zxid != want.Zxid || !reflect.DeepEqual(e.Data, want.Data) {
+			t.Fatalf("entry = %+v; want %+v", *e, want)
+		}
+		if e.Time.IsZero() || time.Since(e.Time) > time.Minute {
+			t.Fatalf("entry %d: time = %v", zxid, e.Time)
+		}
+	}
+	if _, err := it.Next(); err != io.EOF {
+		t.Fatalf("after last entry: err = %v; want EOF", err)
+	}
+}
+
+func TestFileTxnLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 3)
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate crash in the middle of writing next entry
+	path := l.logFileWrite
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0, 0, 0, 1, 0, 0}, l.filePosition)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, dir, 7, 0); len(got) != 3 {
+		t.Fatalf("torn log: read %d entries; want 3", len(got))
+	}
+
+	l, err = OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if zxid := l.GetLastLoggedZxid(); zxid != 3 {
+		t.Fatalf("reopen: last zxid = %d; want 3", zxid)
+	}
+	if !l.Repaired() {
+		t.Fatal("reopen: torn write was not reported")
+	}
+	err = l.AppendEntry([]byte("txn 4"))
+	if err == nil {
+		err = l.Sync()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, dir, 7, 0)
+	if len(got) != 4 || got[3].Zxid != 4 || string(got[3].Data) != "txn 4" {
+		t.Fatalf("after reopen: read %+v", got)
+	}
+}
+
+func TestFileTxnLogCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 2)
+	l.Close()
+
+	// flip a payload byte of the first entry
+	data, err := os.ReadFile(l.logFileWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[fileHeaderSize+entryHeaderSize] ^= 0xff
+	err = os.WriteFile(l.logFileWrite, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := OpenTxnIterator(dir, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	if _, err := it.Next(); err == nil {
+		t.Fatal("corrupt entry was read without error")
+	}
+
+	_, err = OpenFileTxnLog(dir, 7)
+	if err == nil {
+		t.Fatal("corrupt log was opened without error")
+	}
+}
+
+func TestFileTxnLogRollover(t *testing.T) {
+	defer SetTxnLogSizeLimit(txnLogSizeLimit)
+	SetTxnLogSizeLimit(int64(fileHeaderSize + 2*(entryHeaderSize+len("txn 1"))))
+
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendN(t, l, 5)
+
+	segv, err := ListSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zxidv []int64
+	var total int64
+	for i, seg := range segv {
+		zxidv = append(zxidv, seg.Zxid)
+		if i < len(segv)-1 {
+			total += seg.Size
+		}
+	}
+	// the active segment has preallocated space on disk
+	total += l.GetCurrentLogSize()
+	if want := []int64{1, 3, 5}; !reflect.DeepEqual(zxidv, want) {
+		t.Fatalf("segments start at %v; want %v", zxidv, want)
+	}
+	if size := l.GetTotalLogSize(); size != total {
+		t.Fatalf("total log size = %d; want %d", size, total)
+	}
+
+	got := readAll(t, dir, 7, 4)
+	if len(got) != 2 || got[0].Zxid != 4 || got[1].Zxid != 5 {
+		t.Fatalf("read from 4: %+v", got)
+	}
+
+	before := SegmentsBefore(segv, 4)
+	if len(before) != 1 || before[0].Zxid != 1 {
+		t.Fatalf("segments before 4: %+v", before)
+	}
+
+	l.Close()
+	l, err = OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if zxid := l.GetLastLoggedZxid(); zxid != 5 {
+		t.Fatalf("reopen: last zxid = %d; want 5", zxid)
+	}
+	if size := l.GetTotalLogSize(); size != total {
+		t.Fatalf("reopen: total log size = %d; want %d", size, total)
+	}
+}
+
+func TestTxnIteratorFollow(t *testing.T) {
+	defer SetTxnLogSizeLimit(txnLogSizeLimit)
+	SetTxnLogSizeLimit(int64(fileHeaderSize + 2*(entryHeaderSize+len("txn 1"))))
+
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendN(t, l, 1)
+
+	it, err := OpenTxnIterator(dir, 7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	it.Follow()
+
+	// next returns zxids of entries up to the end of the log
+	next := func() []int64 {
+		t.Helper()
+		var zxidv []int64
+		for {
+			e, err := it.Next()
+			if err == io.EOF {
+				return zxidv
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			zxidv = append(zxidv, e.Zxid)
+		}
+	}
+	if got := next(); !reflect.DeepEqual(got, []int64{1}) {
+		t.Fatalf("read: %v", got)
+	}
+	if got := next(); got != nil {
+		t.Fatalf("read at end: %v", got)
+	}
+
+	// entries appended to the same segment and to new ones after rollover
+	appendN(t, l, 4)
+	if got := next(); !reflect.DeepEqual(got, []int64{2, 3, 4, 5}) {
+		t.Fatalf("read after append: %v", got)
+	}
+	appendN(t, l, 1)
+	if got := next(); !reflect.DeepEqual(got, []int64{6}) {
+		t.Fatalf("read after append 2: %v", got)
+	}
+}
@@ -0,0 +1,154 @@
+package lowwatermark
+
+import (
+	"sync"
+	"time"
+)
+
+// GroupCommitConfig tunes batching of AppendEntrySync calls.
+type GroupCommitConfig struct {
+	// MaxDelay is how long a batch waits for more entries before it is
+	// synced. Zero syncs right away; entries appended during that sync
+	// still form the next batch.
+	MaxDelay time.Duration
+
+	// MaxBatchBytes syncs a batch before MaxDelay once that many bytes are
+	// waiting. Zero means no limit.
+	MaxBatchBytes int64
+}
+
+// groupCommit batches appenders waiting for durability so that one Sync
+// serves all of them.
+//
+// The first waiter becomes the leader: it waits for the batch to fill up,
+// syncs the log and wakes everybody up. Waiters whose entries were not
+// covered by that sync elect a new leader.
+type groupCommit struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	config  GroupCommitConfig
+	syncing bool  // whether there is a leader
+	durable int64 // zxid up to which the log is synced
+	synced  int64 // appended bytes up to which the log is synced
+	pending int64 // bytes waiting for sync
+	full    chan struct{}
+
+	// error of failed sync. It is sticky: after failed fsync it is unknown
+	// what part of the log reached disk, so nothing appended afterwards is
+	// reported durable until the log is reopened.
+	err error
+}
+
+func newGroupCommit(durable int64) *groupCommit {
+	g := &groupCommit{durable: durable, full: make(chan struct{}, 1)}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// SetGroupCommit sets how AppendEntrySync calls are batched.
+func (f *FileTxnLog) SetGroupCommit(config GroupCommitConfig) {
+	f.group.mu.Lock()
+	defer f.group.mu.Unlock()
+
+	f.group.config = config
+}
+
+// AppendEntrySync appends txn to the log under next zxid and waits until the
+// entry is durable.
+//
+// Concurrent calls are batched into one Sync according to GroupCommitConfig.
+// zxid of the appended entry is returned.
+//
+// Once a sync fails, this and all following calls fail with its error until
+// the log is reopened.
+func (f *FileTxnLog) AppendEntrySync(txn []byte) (int64, error) {
+	g := f.group
+	g.mu.Lock()
+	err := g.err
+	g.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	f.mu.Lock()
+	zxid := f.lastZxidSeen + 1
+	err = f.appendEntry(zxid, f.now(), txn)
+	f.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending += int64(entryHeaderSize + len(txn))
+	if g.config.MaxBatchBytes > 0 && g.pending >= g.config.MaxBatchBytes {
+		select {
+		case g.full <- struct{}{}:
+		default:
+		}
+	}
+
+	for {
+		if g.durable >= zxid {
+			return zxid, nil
+		}
+		if g.err != nil {
+			return 0, g.err
+		}
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+		g.lead(f)
+	}
+}
+
+// lead collects one batch, syncs the log and wakes up waiters.
+//
+// must be called with .mu held; it is released while syncing.
+func (g *groupCommit) lead(f *FileTxnLog) {
+	g.syncing = true
+	delay := g.config.MaxDelay
+	g.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-g.full:
+			timer.Stop()
+		}
+	}
+
+	g.mu.Lock()
+	g.pending = 0
+	// an early full signal belongs to this batch
+	select {
+	case <-g.full:
+	default:
+	}
+	g.mu.Unlock()
+
+	f.mu.Lock()
+	stats := f.serverStats
+	f.mu.Unlock()
+	zxid, appended, err := f.sync()
+
+	g.mu.Lock()
+	defer g.cond.Broadcast()
+	g.syncing = false
+	if err != nil {
+		g.err = err
+		return
+	}
+	if zxid <= g.durable {
+		return
+	}
+	// the batch is everything appended since last group commit, including
+	// entries of AppendEntry that did not wait
+	if stats != nil {
+		stats.UpdateBatchSize(int(zxid-g.durable), appended-g.synced)
+	}
+	g.durable, g.synced = zxid, appended
+}
@@ -0,0 +1,159 @@
+package lowwatermark
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStats is ServerStats that records fsync and batch reports.
+type recordingStats struct {
+	mu      sync.Mutex
+	fsyncs  int
+	batches []int
+	bytes   int64
+}
+
+func (s *recordingStats) GetNumClients() int     { return 0 }
+func (s *recordingStats) GetNumTxn() int         { return 0 }
+func (s *recordingStats) GetAvgLatency() float64 { return 0 }
+func (s *recordingStats) UpdateFsyncTime(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fsyncs++
+}
+func (s *recordingStats) UpdateAppend(bytes int64) {}
+func (s *recordingStats) UpdateBatchSize(entries int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, entries)
+	s.bytes += bytes
+}
+
+func TestGroupCommit(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stats := &recordingStats{}
+	l.SetServerStats(stats)
+	l.SetGroupCommit(GroupCommitConfig{MaxDelay: 10 * time.Millisecond})
+
+	const N = 50
+	var wg sync.WaitGroup
+	errv := make([]error, N)
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			zxid, err := l.AppendEntrySync([]byte(fmt.Sprintf("txn %d", i)))
+			if err == nil && zxid > l.group.durableZxid() {
+				err = fmt.Errorf("zxid %d returned before it was durable", zxid)
+			}
+			errv[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errv {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := readAll(t, dir, 7, 0); len(got) != N {
+		t.Fatalf("read %d entries; want %d", len(got), N)
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.fsyncs >= N {
+		t.Fatalf("%d fsyncs for %d appends; want them batched", stats.fsyncs, N)
+	}
+	total := 0
+	for _, n := range stats.batches {
+		total += n
+	}
+	if total != N {
+		t.Fatalf("batches %v sum to %d entries; want %d", stats.batches, total, N)
+	}
+}
+
+func TestGroupCommitMaxBatchBytes(t *testing.T) {
+	l, err := OpenFileTxnLog(t.TempDir(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// a batch that never fills up would wait for an hour
+	l.SetGroupCommit(GroupCommitConfig{MaxDelay: time.Hour, MaxBatchBytes: 1})
+
+	done := make(chan error)
+	go func() {
+		_, err := l.AppendEntrySync([]byte("txn"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("full batch was not synced before MaxDelay")
+	}
+}
+
+func TestGroupCommitSyncFailure(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := l.AppendEntrySync([]byte("txn 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// make the next fsync fail: writes still go to the segment through
+	// logStream, but the file being synced is closed
+	fos := l.fos
+	closed, err := os.Open(fos.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	l.mu.Lock()
+	l.fos = closed
+	l.mu.Unlock()
+	if _, err := l.AppendEntrySync([]byte("txn 2")); err == nil {
+		t.Fatal("append: failed sync was not reported")
+	}
+
+	// the disk is fine again, but the log cannot tell what reached it
+	l.mu.Lock()
+	l.fos = fos
+	l.mu.Unlock()
+	if _, err := l.AppendEntrySync([]byte("txn 3")); err == nil {
+		t.Fatal("append after failed sync succeeded")
+	}
+
+	// reopened log is usable again
+	l.Close()
+	l, err = OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := l.AppendEntrySync([]byte("txn 4")); err != nil {
+		t.Fatalf("append after reopen: %s", err)
+	}
+}
+
+func (g *groupCommit) durableZxid() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.durable
+}
@@ -0,0 +1,266 @@
+package lowwatermark
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fsyncBuckets are upper bounds, in seconds, of fsync latency histogram.
+var fsyncBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// batchBytesBuckets are upper bounds, in bytes, of group commit size histogram.
+var batchBytesBuckets = []float64{512, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+const (
+	rateTickInterval = 5 * time.Second // how often append rate is updated
+	rateWindow       = time.Minute     // append rate is averaged over that
+)
+
+// LogStats is ServerStats collecting metrics of a FileTxnLog.
+//
+// Besides the ServerStats getters, metrics are available in Prometheus text
+// format via WritePrometheus and ServeHTTP.
+type LogStats struct {
+	log *FileTxnLog
+	now func() time.Time
+
+	mu            sync.Mutex
+	fsyncCounts   []uint64 // per bucket of fsyncBuckets, plus +Inf
+	fsyncSum      time.Duration
+	fsyncCount    uint64
+	fsyncSlow     uint64 // fsyncs longer than fsyncWarningThresholdMS
+	appends       uint64
+	appendBytes   uint64
+	batches       uint64
+	batchEntries  uint64
+	batchCounts   []uint64 // per bucket of batchBytesBuckets, plus +Inf
+	batchBytes    uint64
+	rate          float64   // appends per second, exponentially averaged over rateWindow
+	rateInit      bool      // whether rate has a first sample
+	rateTick      time.Time // when rate was last updated
+	rateUncounted uint64    // appends since rateTick
+}
+
+// NewLogStats creates LogStats and makes l report to it.
+func NewLogStats(l *FileTxnLog) *LogStats {
+	s := &LogStats{
+		log:         l,
+		now:         time.Now,
+		fsyncCounts: make([]uint64, len(fsyncBuckets)+1),
+		batchCounts: make([]uint64, len(batchBytesBuckets)+1),
+	}
+	s.rateTick = s.now()
+	l.SetServerStats(s)
+	return s
+}
+
+// GetNumClients implements ServerStats.
+//
+// The log has no clients of its own; it always returns 0.
+func (s *LogStats) GetNumClients() int {
+	return 0
+}
+
+// GetNumTxn implements ServerStats.
+//
+// It returns number of entries appended since the stats were created.
+func (s *LogStats) GetNumTxn() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int(s.appends)
+}
+
+// GetAvgLatency implements ServerStats.
+//
+// It returns average fsync latency in milliseconds.
+func (s *LogStats) GetAvgLatency() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fsyncCount == 0 {
+		return 0
+	}
+	return float64(s.fsyncSum.Microseconds()) / 1000 / float64(s.fsyncCount)
+}
+
+// UpdateFsyncTime implements ServerStats.
+func (s *LogStats) UpdateFsyncTime(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fsyncCounts[bucket(fsyncBuckets, elapsed.Seconds())]++
+	s.fsyncSum += elapsed
+	s.fsyncCount++
+	if elapsed.Milliseconds() > fsyncWarningThresholdMS {
+		s.fsyncSlow++
+	}
+}
+
+// UpdateBatchSize implements ServerStats.
+func (s *LogStats) UpdateBatchSize(entries int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches++
+	s.batchEntries += uint64(entries)
+	s.batchCounts[bucket(batchBytesBuckets, float64(bytes))]++
+	s.batchBytes += uint64(bytes)
+}
+
+// bucket returns index of histogram bucket of buckets that v falls into;
+// len(buckets) is the +Inf bucket.
+func bucket(buckets []float64, v float64) int {
+	i := 0
+	for i < len(buckets) && v > buckets[i] {
+		i++
+	}
+	return i
+}
+
+// UpdateAppend implements ServerStats.
+func (s *LogStats) UpdateAppend(bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tickRate()
+	s.appends++
+	s.appendBytes += uint64(bytes)
+	s.rateUncounted++
+}
+
+// tickRate folds appends of every rateTickInterval passed since last update
+// into the append rate.
+//
+// must be called with .mu held.
+func (s *LogStats) tickRate() {
+	now := s.now()
+	alpha := 1 - math.Exp(-rateTickInterval.Seconds()/rateWindow.Seconds())
+	for now.Sub(s.rateTick) >= rateTickInterval {
+		instant := float64(s.rateUncounted) / rateTickInterval.Seconds()
+		s.rateUncounted = 0
+		if s.rateInit {
+			s.rate += alpha * (instant - s.rate)
+		} else {
+			s.rate, s.rateInit = instant, true
+		}
+		s.rateTick = s.rateTick.Add(rateTickInterval)
+	}
+}
+
+// AppendRate returns appends per second averaged over the last minute.
+func (s *LogStats) AppendRate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tickRate()
+	return s.rate
+}
+
+// WritePrometheus writes all metrics in Prometheus text exposition format.
+func (s *LogStats) WritePrometheus(w io.Writer) error {
+	// the log is queried without .mu held: the log reports to s under
+	// its own lock
+	segv, err := ListSegments(s.log.logDir)
+	if err != nil {
+		return err
+	}
+	lastZxid := s.log.GetLastLoggedZxid()
+	lowWaterMark := s.log.GetLowWaterMark()
+	totalSize := s.log.GetTotalLogSize()
+
+	s.mu.Lock()
+	s.tickRate()
+	fsyncCounts := append([]uint64(nil), s.fsyncCounts...)
+	fsyncSum, fsyncCount, fsyncSlow := s.fsyncSum, s.fsyncCount, s.fsyncSlow
+	appends, appendBytes, rate := s.appends, s.appendBytes, s.rate
+	batches, batchEntries := s.batches, s.batchEntries
+	batchCounts := append([]uint64(nil), s.batchCounts...)
+	batchBytes := s.batchBytes
+	s.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	histogram := func(name string, buckets []float64, counts []uint64, sum float64, count uint64) {
+		var cumulative uint64
+		for i, le := range buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(bw, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		cumulative += counts[len(buckets)]
+		fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+		fmt.Fprintf(bw, "%s_sum %g\n", name, sum)
+		fmt.Fprintf(bw, "%s_count %d\n", name, count)
+	}
+
+	metric("txnlog_fsync_seconds", "histogram", "Latency of transaction log fsync.")
+	histogram("txnlog_fsync_seconds", fsyncBuckets, fsyncCounts, fsyncSum.Seconds(), fsyncCount)
+
+	metric("txnlog_fsync_slow_total", "counter", "Fsyncs slower than the fsync warning threshold.")
+	fmt.Fprintf(bw, "txnlog_fsync_slow_total %d\n", fsyncSlow)
+	metric("txnlog_appends_total", "counter", "Entries appended to the log.")
+	fmt.Fprintf(bw, "txnlog_appends_total %d\n", appends)
+	metric("txnlog_append_bytes_total", "counter", "Bytes of entries appended to the log.")
+	fmt.Fprintf(bw, "txnlog_append_bytes_total %d\n", appendBytes)
+	metric("txnlog_append_rate", "gauge", "Appends per second averaged over the last minute.")
+	fmt.Fprintf(bw, "txnlog_append_rate %g\n", rate)
+	metric("txnlog_group_commits_total", "counter", "Group commits synced.")
+	fmt.Fprintf(bw, "txnlog_group_commits_total %d\n", batches)
+	metric("txnlog_group_commit_entries_total", "counter", "Entries made durable by group commits.")
+	fmt.Fprintf(bw, "txnlog_group_commit_entries_total %d\n", batchEntries)
+	metric("txnlog_group_commit_bytes", "histogram", "Bytes of entries made durable by one group commit.")
+	histogram("txnlog_group_commit_bytes", batchBytesBuckets, batchCounts, float64(batchBytes), batches)
+	metric("txnlog_segments", "gauge", "Segment files of the log.")
+	fmt.Fprintf(bw, "txnlog_segments %d\n", len(segv))
+	metric("txnlog_size_bytes", "gauge", "Size of all segments of the log without preallocated space.")
+	fmt.Fprintf(bw, "txnlog_size_bytes %d\n", totalSize)
+	metric("txnlog_last_zxid", "gauge", "Zxid of the last appended entry.")
+	fmt.Fprintf(bw, "txnlog_last_zxid %d\n", lastZxid)
+	metric("txnlog_low_water_mark", "gauge", "Zxid of the newest snapshot.")
+	fmt.Fprintf(bw, "txnlog_low_water_mark %d\n", lowWaterMark)
+	metric("txnlog_low_water_mark_lag", "gauge", "Entries appended after the newest snapshot.")
+	fmt.Fprintf(bw, "txnlog_low_water_mark_lag %d\n", lastZxid-lowWaterMark)
+	return bw.Flush()
+}
+
+// ServeHTTP serves metrics in Prometheus text format.
+func (s *LogStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := s.WritePrometheus(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ServeMetrics serves s at /metrics on addr, for example "127.0.0.1:9141",
+// until ctx is done.
+//
+// It returns error of the HTTP server, or nil once ctx is done.
+func ServeMetrics(ctx context.Context, addr string, s *LogStats) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	stop := context.AfterFunc(ctx, func() { srv.Close() })
+	defer stop()
+
+	err = srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package lowwatermark
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogStats(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stats := NewLogStats(l)
+	now := time.Unix(1700000000, 0)
+	stats.now = func() time.Time { return now }
+	stats.rateTick = now
+
+	appendN(t, l, 10)
+	err = l.TakeSnapshot(4, []byte("state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := stats.GetNumTxn(); n != 10 {
+		t.Fatalf("GetNumTxn = %d; want 10", n)
+	}
+	if stats.fsyncCount != 1 {
+		t.Fatalf("fsyncs = %d; want 1", stats.fsyncCount)
+	}
+	now = now.Add(rateTickInterval)
+	if rate := stats.AppendRate(); rate != 10/rateTickInterval.Seconds() {
+		t.Fatalf("append rate = %g; want %g", rate, 10/rateTickInterval.Seconds())
+	}
+
+	srv := httptest.NewServer(stats)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`txnlog_fsync_seconds_bucket{le="+Inf"} 1`,
+		"txnlog_fsync_seconds_count 1",
+		"txnlog_appends_total 10",
+		"txnlog_append_bytes_total 291", // 10 entryHeaderSize + "txn 1" ... "txn 10"
+		"txnlog_segments 1",
+		"txnlog_last_zxid 10",
+		"txnlog_low_water_mark 4",
+		"txnlog_low_water_mark_lag 6",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestLogStatsBatchBytes(t *testing.T) {
+	l, err := OpenFileTxnLog(t.TempDir(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stats := NewLogStats(l)
+	stats.UpdateBatchSize(1, 100)
+	stats.UpdateBatchSize(3, 1000)
+
+	var buf strings.Builder
+	err = stats.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"txnlog_group_commit_entries_total 4",
+		`txnlog_group_commit_bytes_bucket{le="512"} 1`,
+		`txnlog_group_commit_bytes_bucket{le="4096"} 2`,
+		`txnlog_group_commit_bytes_bucket{le="+Inf"} 2`,
+		"txnlog_group_commit_bytes_sum 1100",
+		"txnlog_group_commit_bytes_count 2",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	l, err := OpenFileTxnLog(t.TempDir(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stats := NewLogStats(l)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := ServeMetrics(context.Background(), ln.Addr().String(), stats); err == nil {
+		t.Fatal("serving on address in use: no error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ServeMetrics(ctx, "127.0.0.1:0", stats) }()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serve after cancel: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop when ctx was done")
+	}
+}
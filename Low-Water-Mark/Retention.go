@@ -0,0 +1,229 @@
+package lowwatermark
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RetentionPolicy tells Cleaner which segments of the log to delete.
+//
+// Unlike snapshot-based low-water mark, retention does not care whether
+// entries were applied anywhere: it suits event logs whose consumers may
+// only read recent entries.
+type RetentionPolicy struct {
+	// Retention is how long entries are kept. A segment is deleted once
+	// its newest entry is older than that. Zero disables time-based
+	// retention.
+	Retention time.Duration
+
+	// MaxTotalSize caps size of the log in bytes. Oldest segments are
+	// deleted while the log is bigger than that. Zero disables the cap.
+	MaxTotalSize int64
+
+	// Interval is how often Cleaner checks the log.
+	Interval time.Duration
+}
+
+// Cleaner deletes segments of FileTxnLog according to RetentionPolicy.
+//
+// The active segment is never deleted.
+type Cleaner struct {
+	log      *FileTxnLog
+	policy   RetentionPolicy
+	onDelete func(deleted []Segment)
+	now      func() time.Time
+
+	mu     sync.Mutex
+	newest map[string]time.Time // segment path -> time of its newest entry
+
+	started  atomic.Bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewCleaner creates Cleaner for log l.
+//
+// onDelete, if not nil, is called with segments deleted by every pass that
+// deleted something.
+func NewCleaner(l *FileTxnLog, policy RetentionPolicy, onDelete func(deleted []Segment)) *Cleaner {
+	return &Cleaner{
+		log:      l,
+		policy:   policy,
+		onDelete: onDelete,
+		now:      time.Now,
+		newest:   make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts cleaning the log in background every policy Interval.
+func (c *Cleaner) Start() {
+	if !c.started.CompareAndSwap(false, true) {
+		return
+	}
+	interval := c.policy.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+			_, err := c.Clean()
+			if err != nil {
+				log.Printf("txnlog: cleaner: %v", err)
+			}
+		}
+	}()
+}
+
+// Stop stops background cleaning started by Start and waits for it to finish.
+func (c *Cleaner) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	if c.started.Load() {
+		<-c.done
+	}
+}
+
+// Clean runs one pass of the cleaner and returns deleted segments.
+func (c *Cleaner) Clean() ([]Segment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segv, err := ListSegments(c.log.logDir)
+	if err != nil {
+		return nil, fmt.Errorf("txnlog: clean: %w", err)
+	}
+	// not summed from segv: the active segment may have preallocated space
+	total := c.log.GetTotalLogSize()
+
+	var expired []Segment
+	var errs []error
+	deadline := c.now().Add(-c.policy.Retention)
+	// the last segment is being appended to, or is the only record of the
+	// last zxid if log has just rolled over
+	for _, seg := range segv[:max(len(segv)-1, 0)] {
+		if c.policy.MaxTotalSize > 0 && total > c.policy.MaxTotalSize {
+			expired = append(expired, seg)
+			total -= seg.Size
+			continue
+		}
+		if c.policy.Retention <= 0 {
+			break
+		}
+		newest, err := c.newestEntryTime(seg)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if !newest.Before(deadline) {
+			break
+		}
+		expired = append(expired, seg)
+		total -= seg.Size
+	}
+
+	c.log.mu.Lock()
+	deleted, err := c.log.removeSegments(expired)
+	c.log.mu.Unlock()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, seg := range deleted {
+		delete(c.newest, seg.Path)
+	}
+	if len(deleted) > 0 && c.onDelete != nil {
+		c.onDelete(deleted)
+	}
+	if len(errs) > 0 {
+		return deleted, fmt.Errorf("txnlog: clean: %w", errors.Join(errs...))
+	}
+	return deleted, nil
+}
+
+// newestEntryTime returns time of the last entry in finished segment seg.
+//
+// must be called with .mu held.
+func (c *Cleaner) newestEntryTime(seg Segment) (time.Time, error) {
+	if t, ok := c.newest[seg.Path]; ok {
+		return t, nil
+	}
+
+	f, err := os.Open(seg.Path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	err = readFileHeader(r, c.log.dbId)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", seg.Path, err)
+	}
+	var newest time.Time
+	for {
+		e, _, err := readEntry(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %w", seg.Path, err)
+		}
+		newest = e.Time
+	}
+
+	// finished segments do not change, so their time can be remembered
+	c.newest[seg.Path] = newest
+	return newest, nil
+}
+
+// removeSegments deletes segv except the active segment and returns deleted
+// segments.
+//
+// Entries before the oldest remaining segment are gone, so low-water mark is
+// raised to the zxid preceding it.
+//
+// must be called with .mu held.
+func (f *FileTxnLog) removeSegments(segv []Segment) ([]Segment, error) {
+	var deleted []Segment
+	var errs []error
+	for _, seg := range segv {
+		if seg.Path == f.logFileWrite {
+			continue
+		}
+		err := os.Remove(seg.Path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		f.prevLogsRunningTotal -= seg.Size
+		deleted = append(deleted, seg)
+	}
+
+	if len(deleted) > 0 {
+		left, err := ListSegments(f.logDir)
+		if err != nil {
+			errs = append(errs, err)
+		} else if len(left) > 0 {
+			f.lowWaterMark = max(f.lowWaterMark, left[0].Zxid-1)
+		}
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -0,0 +1,182 @@
+package lowwatermark
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// openHourlyLog opens log with 2 entries per segment and appends n entries,
+// one every hour from t0.
+func openHourlyLog(t *testing.T, t0 time.Time, n int) *FileTxnLog {
+	t.Helper()
+	limit := txnLogSizeLimit
+	t.Cleanup(func() { SetTxnLogSizeLimit(limit) })
+	SetTxnLogSizeLimit(int64(fileHeaderSize + 2*(entryHeaderSize+len("txn 1"))))
+
+	l, err := OpenFileTxnLog(t.TempDir(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	for i := 0; i < n; i++ {
+		now := t0.Add(time.Duration(i) * time.Hour)
+		l.now = func() time.Time { return now }
+		err := l.AppendEntry([]byte(fmt.Sprintf("txn %d", i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// segmentZxids returns first zxids of segments in log directory.
+func segmentZxids(t *testing.T, l *FileTxnLog) []int64 {
+	t.Helper()
+	segv, err := ListSegments(l.logDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zxidv []int64
+	for _, seg := range segv {
+		zxidv = append(zxidv, seg.Zxid)
+	}
+	return zxidv
+}
+
+func TestCleanerRetention(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := openHourlyLog(t, t0, 6) // segments 1..2, 3..4, 5..6
+
+	var reported []Segment
+	c := NewCleaner(l, RetentionPolicy{Retention: 3 * time.Hour}, func(deleted []Segment) {
+		reported = append(reported, deleted...)
+	})
+	c.now = func() time.Time { return t0.Add(5 * time.Hour) }
+
+	deleted, err := c.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Zxid != 1 {
+		t.Fatalf("deleted %+v; want segment 1", deleted)
+	}
+	if len(reported) != 1 || reported[0] != deleted[0] {
+		t.Fatalf("reported %+v; want %+v", reported, deleted)
+	}
+	if got := segmentZxids(t, l); fmt.Sprint(got) != "[3 5]" {
+		t.Fatalf("segments left: %v", got)
+	}
+
+	// far in the future everything but the active segment expires
+	c.now = func() time.Time { return t0.Add(100 * time.Hour) }
+	_, err = c.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := segmentZxids(t, l); fmt.Sprint(got) != "[5]" {
+		t.Fatalf("segments left: %v", got)
+	}
+	if size := l.GetTotalLogSize(); size != l.GetCurrentLogSize() {
+		t.Fatalf("total log size = %d; want %d", size, l.GetCurrentLogSize())
+	}
+}
+
+func TestCleanerLowWaterMark(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := openHourlyLog(t, t0, 6) // segments 1..2, 3..4, 5..6
+	err := l.TakeSnapshot(1, []byte("state@1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCleaner(l, RetentionPolicy{Retention: 3 * time.Hour}, nil)
+	c.now = func() time.Time { return t0.Add(5 * time.Hour) }
+	_, err = c.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lwm := l.GetLowWaterMark(); lwm != 2 {
+		t.Fatalf("low-water mark = %d; want 2", lwm)
+	}
+
+	// recovery continues after entries deleted by retention
+	checkRecover := func(l *FileTxnLog) {
+		t.Helper()
+		var applied []int64
+		zxid, err := l.Recover(
+			func(zxid int64, data []byte) error { return nil },
+			func(e *TxnEntry) error {
+				applied = append(applied, e.Zxid)
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(applied) != "[3 4 5 6]" || zxid != 6 {
+			t.Fatalf("recover: applied %v up to %d; want [3 4 5 6] up to 6", applied, zxid)
+		}
+	}
+	checkRecover(l)
+
+	l.Close()
+	l, err = OpenFileTxnLog(l.logDir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if lwm := l.GetLowWaterMark(); lwm != 2 {
+		t.Fatalf("reopen: low-water mark = %d; want 2", lwm)
+	}
+	checkRecover(l)
+}
+
+func TestCleanerMaxTotalSize(t *testing.T) {
+	t0 := time.Now()
+	l := openHourlyLog(t, t0, 6)
+
+	segSize := l.GetCurrentLogSize()
+	c := NewCleaner(l, RetentionPolicy{MaxTotalSize: 2 * segSize}, nil)
+	deleted, err := c.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Zxid != 1 {
+		t.Fatalf("deleted %+v; want segment 1", deleted)
+	}
+
+	c = NewCleaner(l, RetentionPolicy{MaxTotalSize: 1}, nil)
+	_, err = c.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := segmentZxids(t, l); fmt.Sprint(got) != "[5]" {
+		t.Fatalf("segments left: %v", got)
+	}
+}
+
+func TestCleanerStartStop(t *testing.T) {
+	t0 := time.Now().Add(-24 * time.Hour)
+	l := openHourlyLog(t, t0, 4)
+
+	deletedq := make(chan []Segment, 1)
+	c := NewCleaner(l, RetentionPolicy{Retention: time.Hour, Interval: time.Millisecond}, func(deleted []Segment) {
+		deletedq <- deleted
+	})
+	c.Start()
+	select {
+	case deleted := <-deletedq:
+		if len(deleted) != 1 || deleted[0].Zxid != 1 {
+			t.Errorf("deleted %+v; want segment 1", deleted)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("cleaner did not delete expired segment")
+	}
+	c.Stop()
+	c.Stop() // idempotent
+}
@@ -0,0 +1,118 @@
+package lowwatermark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Segment is one file of transaction log.
+//
+// The log is split into segment files named LOG_FILE_PREFIX.<zxid>, where
+// zxid, in hex, is zxid of the first entry in the segment.
+type Segment struct {
+	Path string
+	Zxid int64 // zxid of the first entry in the segment
+	Size int64 // size of the file in bytes
+}
+
+// logFileName returns name of segment whose first entry has zxid.
+func logFileName(zxid int64) string {
+	return LOG_FILE_PREFIX + "." + strconv.FormatInt(zxid, 16)
+}
+
+// parseLogFileName returns zxid encoded in segment file name.
+func parseLogFileName(name string) (zxid int64, ok bool) {
+	hex, ok := strings.CutPrefix(name, LOG_FILE_PREFIX+".")
+	if !ok {
+		return 0, false
+	}
+	zxid, err := strconv.ParseInt(hex, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return zxid, true
+}
+
+// ListSegments returns segments of transaction log in logDir sorted by zxid.
+//
+// Files not named as segments are ignored.
+func ListSegments(logDir string) ([]Segment, error) {
+	entryv, err := os.ReadDir(logDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segv []Segment
+	for _, entry := range entryv {
+		if entry.IsDir() {
+			continue
+		}
+		zxid, ok := parseLogFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // removed concurrently
+			}
+			return nil, err
+		}
+		segv = append(segv, Segment{
+			Path: filepath.Join(logDir, entry.Name()),
+			Zxid: zxid,
+			Size: info.Size(),
+		})
+	}
+	sort.Slice(segv, func(i, j int) bool { return segv[i].Zxid < segv[j].Zxid })
+	return segv, nil
+}
+
+// SegmentsFrom returns segments from sorted segv that may contain entries
+// with zxid or later.
+//
+// That is the last segment starting at or before zxid and all segments after
+// it.
+func SegmentsFrom(segv []Segment, zxid int64) []Segment {
+	start := 0
+	for i, seg := range segv {
+		if seg.Zxid <= zxid {
+			start = i
+		}
+	}
+	return segv[start:]
+}
+
+// SegmentsBefore returns segments from sorted segv that contain only entries
+// before zxid.
+//
+// Those segments are not needed to read the log from zxid and can be removed.
+func SegmentsBefore(segv []Segment, zxid int64) []Segment {
+	from := SegmentsFrom(segv, zxid)
+	return segv[:len(segv)-len(from)]
+}
+
+// LogDbId returns dbId of log in logDir as recorded in its oldest segment.
+func LogDbId(logDir string) (int64, error) {
+	segv, err := ListSegments(logDir)
+	if err != nil {
+		return 0, err
+	}
+	if len(segv) == 0 {
+		return 0, fmt.Errorf("txnlog: %s: no log segments", logDir)
+	}
+	f, err := os.Open(segv[0].Path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	dbId, err := parseFileHeader(f)
+	if err != nil {
+		return 0, fmt.Errorf("txnlog: %s: %w", segv[0].Path, err)
+	}
+	return dbId, nil
+}
@@ -0,0 +1,290 @@
+package lowwatermark
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SNAP_MAGIC           = 0x5a4b534e // "ZKSN" in integer format
+	SNAPSHOT_FILE_PREFIX = "snapshot"
+
+	snapHeaderSize = 20 // zxid(8) length(8) crc(4), after file header
+)
+
+// SnapshotInfo describes snapshot file found in log directory.
+//
+// Snapshots are named SNAPSHOT_FILE_PREFIX.<zxid>, where zxid, in hex, is
+// zxid of the last entry applied to the state in the snapshot.
+type SnapshotInfo struct {
+	Path string
+	Zxid int64
+	Size int64
+}
+
+// snapFileName returns name of snapshot taken at zxid.
+func snapFileName(zxid int64) string {
+	return SNAPSHOT_FILE_PREFIX + "." + strconv.FormatInt(zxid, 16)
+}
+
+// ListSnapshots returns snapshots in logDir sorted by zxid.
+func ListSnapshots(logDir string) ([]SnapshotInfo, error) {
+	entryv, err := os.ReadDir(logDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapv []SnapshotInfo
+	for _, entry := range entryv {
+		hex, ok := strings.CutPrefix(entry.Name(), SNAPSHOT_FILE_PREFIX+".")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		zxid, err := strconv.ParseInt(hex, 16, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // removed concurrently
+			}
+			return nil, err
+		}
+		snapv = append(snapv, SnapshotInfo{
+			Path: filepath.Join(logDir, entry.Name()),
+			Zxid: zxid,
+			Size: info.Size(),
+		})
+	}
+	sort.Slice(snapv, func(i, j int) bool { return snapv[i].Zxid < snapv[j].Zxid })
+	return snapv, nil
+}
+
+// writeSnapshot durably writes snapshot data taken at zxid into logDir.
+//
+// The snapshot is written into a temporary file which is renamed into place
+// only after it is synced, so that a crash never leaves a partial snapshot.
+func writeSnapshot(logDir string, dbId, zxid int64, data []byte) (SnapshotInfo, error) {
+	path := filepath.Join(logDir, snapFileName(zxid))
+	tmp := filepath.Join(logDir, "."+snapFileName(zxid)+".tmp")
+
+	var hdr [fileHeaderSize + snapHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:], SNAP_MAGIC)
+	binary.BigEndian.PutUint32(hdr[4:], VERSION)
+	binary.BigEndian.PutUint64(hdr[8:], uint64(dbId))
+	binary.BigEndian.PutUint64(hdr[16:], uint64(zxid))
+	binary.BigEndian.PutUint64(hdr[24:], uint64(len(data)))
+	binary.BigEndian.PutUint32(hdr[32:], crc32.Checksum(data, crcTable))
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	_, err = f.Write(hdr[:])
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		err = syncDir(logDir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return SnapshotInfo{}, err
+	}
+	return SnapshotInfo{Path: path, Zxid: zxid, Size: int64(len(hdr) + len(data))}, nil
+}
+
+// readSnapshot reads and verifies snapshot at path.
+func readSnapshot(path string, dbId int64) (zxid int64, data []byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var hdr [fileHeaderSize + snapHeaderSize]byte
+	_, err = io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: header: %w", path, err)
+	}
+	if magic := binary.BigEndian.Uint32(hdr[0:]); magic != SNAP_MAGIC {
+		return 0, nil, fmt.Errorf("%s: header: invalid magic %#x", path, magic)
+	}
+	if version := binary.BigEndian.Uint32(hdr[4:]); version != VERSION {
+		return 0, nil, fmt.Errorf("%s: header: unsupported version %d", path, version)
+	}
+	if id := int64(binary.BigEndian.Uint64(hdr[8:])); id != dbId {
+		return 0, nil, fmt.Errorf("%s: header: dbId %d, expected %d", path, id, dbId)
+	}
+
+	zxid = int64(binary.BigEndian.Uint64(hdr[16:]))
+	length := binary.BigEndian.Uint64(hdr[24:])
+	data, err = io.ReadAll(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if uint64(len(data)) != length || crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(hdr[32:]) {
+		return 0, nil, fmt.Errorf("%s: %w", path, ErrCorrupt)
+	}
+	return zxid, data, nil
+}
+
+// syncDir syncs directory entries at dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if errClose := d.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// TakeSnapshot durably stores snapshot data of the state machine with all
+// entries up to and including zxid applied.
+//
+// zxid becomes the low-water mark of the log: older snapshots and every
+// segment containing only entries at or below zxid are deleted, as they are
+// no longer needed for recovery. The current segment is never deleted.
+func (f *FileTxnLog) TakeSnapshot(zxid int64, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if zxid < f.lowWaterMark {
+		return fmt.Errorf("txnlog: snapshot: zxid %#x is below low-water mark %#x", zxid, f.lowWaterMark)
+	}
+	if zxid > f.lastZxidSeen {
+		return fmt.Errorf("txnlog: snapshot: zxid %#x is after last logged zxid %#x", zxid, f.lastZxidSeen)
+	}
+
+	_, err := writeSnapshot(f.logDir, f.dbId, zxid, data)
+	if err != nil {
+		return fmt.Errorf("txnlog: snapshot: %w", err)
+	}
+	f.lowWaterMark = zxid
+
+	err = f.truncate()
+	if err != nil {
+		return fmt.Errorf("txnlog: snapshot: %w", err)
+	}
+	return nil
+}
+
+// truncate deletes snapshots and segments below low-water mark.
+//
+// must be called with .mu held.
+func (f *FileTxnLog) truncate() error {
+	var errs []error
+
+	snapv, err := ListSnapshots(f.logDir)
+	if err != nil {
+		return err
+	}
+	for _, snap := range snapv {
+		if snap.Zxid < f.lowWaterMark {
+			err := os.Remove(snap.Path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	segv, err := ListSegments(f.logDir)
+	if err != nil {
+		return err
+	}
+	_, err = f.removeSegments(SegmentsBefore(segv, f.lowWaterMark+1))
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// GetLowWaterMark returns zxid of the newest snapshot, or of the last entry
+// deleted by retention if that is newer.
+//
+// Entries at or below the low-water mark may be no longer present in the log.
+func (f *FileTxnLog) GetLowWaterMark() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lowWaterMark
+}
+
+// Recover restores state machine from the newest snapshot and the log suffix
+// after it.
+//
+// restore is called with the newest snapshot, if there is any, and then apply
+// is called for every logged entry after the snapshot in zxid order. zxid of
+// the last restored entry is returned.
+//
+// Entries deleted by retention after the snapshot was taken are skipped:
+// apply continues from the low-water mark.
+func (f *FileTxnLog) Recover(restore func(zxid int64, data []byte) error, apply func(e *TxnEntry) error) (int64, error) {
+	snapv, err := ListSnapshots(f.logDir)
+	if err != nil {
+		return 0, fmt.Errorf("txnlog: recover: %w", err)
+	}
+
+	var zxid int64
+	if len(snapv) > 0 {
+		var data []byte
+		zxid, data, err = readSnapshot(snapv[len(snapv)-1].Path, f.dbId)
+		if err != nil {
+			return 0, fmt.Errorf("txnlog: recover: %w", err)
+		}
+		err = restore(zxid, data)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	f.mu.Lock()
+	prev := max(zxid, f.lowWaterMark)
+	f.mu.Unlock()
+
+	it, err := f.Read(prev + 1)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	for {
+		e, err := it.Next()
+		if err == io.EOF {
+			return zxid, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("txnlog: recover: %w", err)
+		}
+		if e.Zxid != prev+1 && prev != 0 {
+			return 0, fmt.Errorf("txnlog: recover: missing entries %#x..%#x", prev+1, e.Zxid-1)
+		}
+		err = apply(e)
+		if err != nil {
+			return 0, err
+		}
+		zxid, prev = e.Zxid, e.Zxid
+	}
+}
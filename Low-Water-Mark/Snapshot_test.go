@@ -0,0 +1,102 @@
+package lowwatermark
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTakeSnapshotTruncates(t *testing.T) {
+	defer SetTxnLogSizeLimit(txnLogSizeLimit)
+	SetTxnLogSizeLimit(int64(fileHeaderSize + 2*(entryHeaderSize+len("txn 1"))))
+
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendN(t, l, 6)
+
+	err = l.TakeSnapshot(2, []byte("state@2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.TakeSnapshot(4, []byte("state@4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lwm := l.GetLowWaterMark(); lwm != 4 {
+		t.Fatalf("low-water mark = %d; want 4", lwm)
+	}
+	if err := l.TakeSnapshot(3, nil); err == nil {
+		t.Fatal("snapshot below low-water mark succeeded")
+	}
+	if err := l.TakeSnapshot(7, nil); err == nil {
+		t.Fatal("snapshot after last logged zxid succeeded")
+	}
+
+	segv, err := ListSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segv) != 1 || segv[0].Zxid != 5 {
+		t.Fatalf("segments after truncation: %+v", segv)
+	}
+	if size, want := l.GetTotalLogSize(), l.GetCurrentLogSize(); size != want {
+		t.Fatalf("total log size = %d; want %d", size, want)
+	}
+	snapv, err := ListSnapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapv) != 1 || snapv[0].Zxid != 4 {
+		t.Fatalf("snapshots after truncation: %+v", snapv)
+	}
+}
+
+func TestRecoverFromSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 5)
+	err = l.TakeSnapshot(3, []byte("state@3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if lwm := l.GetLowWaterMark(); lwm != 3 {
+		t.Fatalf("reopen: low-water mark = %d; want 3", lwm)
+	}
+
+	var restored string
+	var applied []int64
+	zxid, err := l.Recover(
+		func(zxid int64, data []byte) error {
+			restored = string(data)
+			return nil
+		},
+		func(e *TxnEntry) error {
+			applied = append(applied, e.Zxid)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != "state@3" {
+		t.Fatalf("restored %q; want %q", restored, "state@3")
+	}
+	if want := []int64{4, 5}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v; want %v", applied, want)
+	}
+	if zxid != 5 {
+		t.Fatalf("recovered up to %d; want 5", zxid)
+	}
+}
@@ -0,0 +1,101 @@
+package lowwatermark
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// TxnHeader is header of a structured transaction in the log.
+//
+// It is encoded as jute TxnHeader record, so that logged transactions can be
+// read by ZooKeeper tools.
+type TxnHeader struct {
+	ClientId int64 // session id of the client that issued the transaction
+	Cxid     int   // client transaction id
+	Zxid     int64
+	Time     int64 // milliseconds since epoch
+	Type     int
+}
+
+// Serialize implements Record.
+func (h *TxnHeader) Serialize(oa OutputArchive) error {
+	if err := oa.WriteLong(h.ClientId); err != nil {
+		return err
+	}
+	if err := oa.WriteInt(h.Cxid); err != nil {
+		return err
+	}
+	if err := oa.WriteLong(h.Zxid); err != nil {
+		return err
+	}
+	if err := oa.WriteLong(h.Time); err != nil {
+		return err
+	}
+	return oa.WriteInt(h.Type)
+}
+
+// Deserialize implements Record.
+func (h *TxnHeader) Deserialize(ia InputArchive) (err error) {
+	if h.ClientId, err = ia.ReadLong(); err != nil {
+		return err
+	}
+	if h.Cxid, err = ia.ReadInt(); err != nil {
+		return err
+	}
+	if h.Zxid, err = ia.ReadLong(); err != nil {
+		return err
+	}
+	if h.Time, err = ia.ReadLong(); err != nil {
+		return err
+	}
+	h.Type, err = ia.ReadInt()
+	return err
+}
+
+// AppendTxn appends transaction txn with header hdr to the log.
+//
+// hdr.Zxid must directly follow the last logged zxid, as Recover rejects
+// gaps; only the first transaction of an empty log can start anywhere. Entry
+// time is taken from hdr.Time, which must be set: an entry from 1970 would
+// be removed by the first retention run. txn may be nil for transactions
+// that consist of the header only.
+func (f *FileTxnLog) AppendTxn(hdr *TxnHeader, txn Record) error {
+	if hdr.Time <= 0 {
+		return fmt.Errorf("txnlog: append: zxid %#x: transaction header has no time", hdr.Zxid)
+	}
+
+	var buf bytes.Buffer
+	oa := NewBinaryOutputArchive(&buf)
+	err := oa.WriteRecord(hdr)
+	if err == nil && txn != nil {
+		err = oa.WriteRecord(txn)
+	}
+	if err != nil {
+		return fmt.Errorf("txnlog: append: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lastZxidSeen != 0 && hdr.Zxid != f.lastZxidSeen+1 {
+		return fmt.Errorf("txnlog: append: zxid %#x does not follow last zxid %#x", hdr.Zxid, f.lastZxidSeen)
+	}
+	return f.appendEntry(hdr.Zxid, time.UnixMilli(hdr.Time), buf.Bytes())
+}
+
+// ReadTxn decodes entry appended by AppendTxn.
+//
+// The transaction itself is decoded into txn unless it is nil.
+func ReadTxn(e *TxnEntry, txn Record) (*TxnHeader, error) {
+	ia := NewBinaryInputArchive(bytes.NewReader(e.Data))
+	hdr := &TxnHeader{}
+	err := ia.ReadRecord(hdr)
+	if err == nil && txn != nil {
+		err = ia.ReadRecord(txn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("txnlog: zxid %#x: %w", e.Zxid, err)
+	}
+	return hdr, nil
+}
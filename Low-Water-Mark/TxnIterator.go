@@ -0,0 +1,249 @@
+package lowwatermark
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// readFileHeader reads and verifies log file header.
+func readFileHeader(r io.Reader, dbId int64) error {
+	id, err := parseFileHeader(r)
+	if err != nil {
+		return err
+	}
+	if id != dbId {
+		return fmt.Errorf("header: dbId %d, expected %d", id, dbId)
+	}
+	return nil
+}
+
+// parseFileHeader reads log file header and returns dbId recorded in it.
+func parseFileHeader(r io.Reader) (dbId int64, err error) {
+	var hdr [fileHeaderSize]byte
+	_, err = io.ReadFull(r, hdr[:])
+	if err != nil {
+		return 0, fmt.Errorf("header: %w", err)
+	}
+	if magic := binary.BigEndian.Uint32(hdr[0:]); magic != TXNLOG_MAGIC {
+		return 0, fmt.Errorf("header: invalid magic %#x", magic)
+	}
+	if version := binary.BigEndian.Uint32(hdr[4:]); version != VERSION {
+		return 0, fmt.Errorf("header: unsupported version %d", version)
+	}
+	return int64(binary.BigEndian.Uint64(hdr[8:])), nil
+}
+
+// readEntry reads one entry and returns it together with its size on disk.
+//
+// io.EOF is returned if there are no more entries; io.ErrUnexpectedEOF if
+// the last entry is not complete. Zero-filled space preallocated by
+// FilePadding is treated as end of entries. With ErrCorrupt the returned size
+// is how much space the corrupt entry claims to take.
+func readEntry(r io.Reader) (*TxnEntry, int64, error) {
+	var hdr [entryHeaderSize]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if hdr == ([entryHeaderSize]byte{}) {
+		// every entry has nonzero zxid
+		return nil, 0, io.EOF
+	}
+	crc := binary.BigEndian.Uint32(hdr[0:])
+	length := binary.BigEndian.Uint32(hdr[4:])
+	if length > maxEntrySize {
+		// the length cannot be trusted; only the header is known to be bad
+		return nil, entryHeaderSize, fmt.Errorf("%w: length %d", ErrCorrupt, length)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sum := crc32.Update(0, crcTable, hdr[8:])
+	sum = crc32.Update(sum, crcTable, data)
+	if sum != crc {
+		return nil, int64(entryHeaderSize) + int64(length), ErrCorrupt
+	}
+
+	e := &TxnEntry{
+		Zxid: int64(binary.BigEndian.Uint64(hdr[8:])),
+		Time: time.UnixMilli(int64(binary.BigEndian.Uint64(hdr[16:]))),
+		Data: data,
+	}
+	return e, int64(entryHeaderSize) + int64(length), nil
+}
+
+// TxnIterator iterates over entries of transaction log in zxid order.
+type TxnIterator struct {
+	logDir string
+	dbId   int64
+	zxid   int64     // first zxid to return
+	files  []Segment // segments not yet opened
+	follow bool      // whether to wait at the end of the log for new entries
+
+	f    *os.File // log file being read
+	seg  Segment  // segment of f
+	r    *bufio.Reader
+	off  int64 // offset of next entry in f
+	last bool  // whether f is the last log file
+}
+
+// OpenTxnIterator opens iterator over entries of log in logDir starting from
+// zxid.
+func OpenTxnIterator(logDir string, dbId, zxid int64) (*TxnIterator, error) {
+	segv, err := ListSegments(logDir)
+	if err != nil {
+		return nil, fmt.Errorf("txnlog: iterator: %w", err)
+	}
+	return &TxnIterator{logDir: logDir, dbId: dbId, zxid: zxid, files: SegmentsFrom(segv, zxid)}, nil
+}
+
+// Follow makes the iterator follow the log as it is appended to.
+//
+// At the end of the log Next still returns io.EOF, but the iterator stays
+// where it is: following calls to Next return entries appended since,
+// including those in segments created by rollover.
+func (it *TxnIterator) Follow() {
+	it.follow = true
+}
+
+// newSegments returns segments of the log that appeared after the segment
+// being read, or after the iterator was opened.
+func (it *TxnIterator) newSegments() ([]Segment, error) {
+	segv, err := ListSegments(it.logDir)
+	if err != nil {
+		return nil, err
+	}
+	if it.f == nil {
+		return SegmentsFrom(segv, it.zxid), nil
+	}
+	for i, seg := range segv {
+		if seg.Zxid > it.seg.Zxid {
+			return segv[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// Next returns next entry.
+//
+// io.EOF is returned when there are no more entries.
+func (it *TxnIterator) Next() (*TxnEntry, error) {
+	for {
+		if it.f == nil {
+			if len(it.files) == 0 && it.follow {
+				var err error
+				it.files, err = it.newSegments()
+				if err != nil {
+					return nil, fmt.Errorf("txnlog: iterator: %w", err)
+				}
+			}
+			if len(it.files) == 0 {
+				return nil, io.EOF
+			}
+			err := it.open(it.files[0])
+			if err != nil {
+				return nil, fmt.Errorf("txnlog: iterator: %w", err)
+			}
+			it.files = it.files[1:]
+			it.last = len(it.files) == 0
+		}
+
+		e, n, err := readEntry(it.r)
+		if err != nil {
+			torn, err := checkTail(it.f, it.off, n, err)
+			if err == nil && torn && !it.last {
+				// only the segment being appended to may end with
+				// an incomplete entry
+				err = &CorruptionError{Path: it.f.Name(), Offset: it.off, Err: ErrCorrupt}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("txnlog: iterator: %w", err)
+			}
+
+			// an incomplete entry at the end of the log is being
+			// written or was torn by a crash
+			if it.last && it.follow {
+				more, err := it.rewind()
+				if err != nil {
+					return nil, fmt.Errorf("txnlog: iterator: %w", err)
+				}
+				if !more {
+					return nil, io.EOF
+				}
+				continue
+			}
+			it.f.Close()
+			it.f, it.r = nil, nil
+			if it.last {
+				return nil, io.EOF
+			}
+			continue
+		}
+		it.off += n
+		if e.Zxid < it.zxid {
+			continue
+		}
+		return e, nil
+	}
+}
+
+// rewind positions the iterator following the log back to the end of the
+// last entry read from the last segment, where the next entry is appended.
+//
+// It reports whether the log has since rolled over to new segments; the rest
+// of the current segment is then read first.
+func (it *TxnIterator) rewind() (more bool, _ error) {
+	files, err := it.newSegments()
+	if err != nil {
+		return false, err
+	}
+	_, err = it.f.Seek(it.off, io.SeekStart)
+	if err != nil {
+		return false, err
+	}
+	it.r.Reset(it.f)
+	if len(files) == 0 {
+		return false, nil
+	}
+	it.files, it.last = files, false
+	return true, nil
+}
+
+// open starts reading segment seg.
+func (it *TxnIterator) open(seg Segment) error {
+	f, err := os.Open(seg.Path)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	err = readFileHeader(r, it.dbId)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", seg.Path, err)
+	}
+	it.f, it.seg, it.r, it.off = f, seg, r, fileHeaderSize
+	return nil
+}
+
+// Close releases resources of the iterator.
+func (it *TxnIterator) Close() error {
+	it.files = nil
+	if it.f == nil {
+		return nil
+	}
+	err := it.f.Close()
+	it.f, it.r = nil, nil
+	return err
+}
@@ -0,0 +1,173 @@
+package lowwatermark
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// CorruptionError reports corrupt data in the middle of a segment.
+//
+// Unlike a torn write at the end of the log, which is repaired on open, such
+// corruption means that entries were damaged after they were written and is
+// never repaired automatically.
+type CorruptionError struct {
+	Path   string
+	Offset int64 // offset of the first bad entry
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s: offset %d: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error { return e.Err }
+
+// checkTail tells whether entries of f end at off because of a torn write.
+//
+// readErr and n are what readEntry returned for the entry at off. The end is
+// torn if the entry at off is incomplete or corrupt and nothing but zeros
+// follows it. If something follows, corruption is in the middle of the
+// segment and CorruptionError is returned.
+func checkTail(f *os.File, off, n int64, readErr error) (torn bool, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := info.Size()
+
+	var from int64
+	switch {
+	case readErr == io.EOF:
+		// clean end of entries or start of preallocated space
+		from = off
+	case readErr == io.ErrUnexpectedEOF:
+		// the file ends inside the entry
+		return true, nil
+	case errors.Is(readErr, ErrCorrupt):
+		from = min(off+n, size)
+	default:
+		return false, readErr
+	}
+
+	zero, err := allZero(f, from, size)
+	if err != nil {
+		return false, err
+	}
+	if !zero {
+		return false, &CorruptionError{Path: f.Name(), Offset: off, Err: ErrCorrupt}
+	}
+	return readErr != io.EOF, nil
+}
+
+// allZero tells whether bytes of f in [from, to) are all zero.
+func allZero(f *os.File, from, to int64) (bool, error) {
+	buf := make([]byte, 64*1024)
+	for off := from; off < to; {
+		n, err := f.ReadAt(buf[:min(int64(len(buf)), to-off)], off)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// SegmentReport is result of scanning one segment.
+type SegmentReport struct {
+	Segment
+	DbId      int64
+	Entries   int
+	FirstZxid int64 // zxid of the first entry; 0 if there are no entries
+	LastZxid  int64 // zxid of the last entry; 0 if there are no entries
+	FirstTime time.Time
+	LastTime  time.Time
+	ValidSize int64 // offset right after the last valid entry
+	Torn      bool  // whether an incomplete or corrupt entry follows ValidSize
+	Err       error // why the segment could not be scanned to its end
+}
+
+// ScanSegment reads all entries of seg and verifies their checksums.
+//
+// Corruption in the middle of the segment is reported as *CorruptionError in
+// Err.
+func ScanSegment(seg Segment) SegmentReport {
+	rep := SegmentReport{Segment: seg}
+	f, err := os.Open(seg.Path)
+	if err != nil {
+		rep.Err = err
+		return rep
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	rep.DbId, err = parseFileHeader(r)
+	if err != nil {
+		rep.Err = fmt.Errorf("%s: %w", seg.Path, err)
+		return rep
+	}
+
+	off := int64(fileHeaderSize)
+	for {
+		e, n, err := readEntry(r)
+		if err != nil {
+			rep.ValidSize = off
+			rep.Torn, rep.Err = checkTail(f, off, n, err)
+			return rep
+		}
+		rep.Entries++
+		if rep.Entries == 1 {
+			rep.FirstZxid, rep.FirstTime = e.Zxid, e.Time
+		}
+		rep.LastZxid, rep.LastTime = e.Zxid, e.Time
+		off += n
+	}
+}
+
+// VerifyLog scans every segment of log in logDir.
+//
+// Besides checksums it verifies that all segments belong to the same
+// database, that only the last segment has a torn tail and that zxids do not
+// go back from one segment to the next. All problems found are returned
+// joined into error; reports of all segments are returned anyway.
+func VerifyLog(logDir string) ([]SegmentReport, error) {
+	segv, err := ListSegments(logDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []SegmentReport
+	var errs []error
+	var lastZxid int64
+	for i, seg := range segv {
+		rep := ScanSegment(seg)
+		reports = append(reports, rep)
+		switch {
+		case rep.Err != nil:
+			errs = append(errs, rep.Err)
+		case rep.DbId != reports[0].DbId:
+			errs = append(errs, fmt.Errorf("%s: dbId %d, expected %d", seg.Path, rep.DbId, reports[0].DbId))
+		case rep.Torn && i < len(segv)-1:
+			errs = append(errs, &CorruptionError{Path: seg.Path, Offset: rep.ValidSize, Err: ErrCorrupt})
+		case rep.Entries > 0 && rep.FirstZxid != seg.Zxid:
+			errs = append(errs, fmt.Errorf("%s: first zxid %#x does not match file name", seg.Path, rep.FirstZxid))
+		case rep.Entries > 0 && rep.FirstZxid <= lastZxid:
+			errs = append(errs, fmt.Errorf("%s: zxid %#x goes back from %#x", seg.Path, rep.FirstZxid, lastZxid))
+		}
+		if rep.Entries > 0 {
+			lastZxid = rep.LastZxid
+		}
+	}
+	return reports, errors.Join(errs...)
+}
@@ -0,0 +1,111 @@
+package lowwatermark
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// writeLog creates log in dir with entries "txn 1" ... "txn n" and returns
+// path of its last segment and offset right after the last entry.
+func writeLog(t *testing.T, dir string, n int) (path string, end int64) {
+	t.Helper()
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, n)
+	path, end = l.logFileWrite, l.filePosition
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, end
+}
+
+func TestTornTailRepair(t *testing.T) {
+	dir := t.TempDir()
+	path, end := writeLog(t, dir, 3)
+
+	// entry header claiming 100 bytes of payload, of which only a few made
+	// it to disk before the crash; preallocated zeros follow
+	var torn [entryHeaderSize + 4]byte
+	binary.BigEndian.PutUint32(torn[0:], 0xdeadbeef)
+	binary.BigEndian.PutUint32(torn[4:], 100)
+	binary.BigEndian.PutUint64(torn[8:], 4)
+	copy(torn[entryHeaderSize:], "txn ")
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(torn[:], end)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := VerifyLog(dir)
+	if err != nil {
+		t.Fatalf("verify: torn tail of the last segment is not an error: %v", err)
+	}
+	if rep := reports[0]; !rep.Torn || rep.ValidSize != end || rep.Entries != 3 || rep.LastZxid != 3 {
+		t.Fatalf("verify: report %+v", rep)
+	}
+
+	l, err := OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if !l.Repaired() {
+		t.Fatal("torn write was not reported")
+	}
+	if zxid := l.GetLastLoggedZxid(); zxid != 3 {
+		t.Fatalf("last zxid = %d; want 3", zxid)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != end {
+		t.Fatalf("size after repair = %d; want %d", info.Size(), end)
+	}
+}
+
+func TestMidSegmentCorruption(t *testing.T) {
+	dir := t.TempDir()
+	path, _ := writeLog(t, dir, 3)
+
+	// flip a payload byte of the second entry
+	second := int64(fileHeaderSize + entryHeaderSize + len("txn 1"))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[second+entryHeaderSize] ^= 0xff
+	err = os.WriteFile(path, data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFileTxnLog(dir, 7)
+	var cerr *CorruptionError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("open: err = %v; want CorruptionError", err)
+	}
+	if cerr.Offset != second || cerr.Path != path {
+		t.Fatalf("open: corruption reported at %s:%d; want %s:%d", cerr.Path, cerr.Offset, path, second)
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("open: err = %v; want ErrCorrupt", err)
+	}
+
+	reports, err := VerifyLog(dir)
+	if !errors.As(err, &cerr) || cerr.Offset != second {
+		t.Fatalf("verify: err = %v; want corruption at offset %d", err, second)
+	}
+	if rep := reports[0]; rep.Entries != 1 || rep.ValidSize != second {
+		t.Fatalf("verify: report %+v", rep)
+	}
+}
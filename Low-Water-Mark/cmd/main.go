@@ -0,0 +1,383 @@
+// Command cmd inspects transaction logs written by FileTxnLog.
+//
+// It only reads the log directory: torn tails are reported, not repaired.
+//
+// Usage:
+//
+//	cmd segments <logDir>
+//	cmd dump [-from zxid] [-to zxid] [-format text|json] [-txn] <logDir>
+//	cmd snapshots <logDir>
+//	cmd tail [-from zxid] [-interval d] [-format text|json] [-txn] <logDir>
+//	cmd verify <logDir>
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	lowwatermark "github.com/zacksfF/Distributed-Systems-patterns/Low-Water-Mark"
+)
+
+const usage = `usage: %s <command> [arguments]
+
+commands:
+  segments <logDir>    list segments with their zxid range, size and times
+  dump <logDir>        print entries in a zxid range as text or JSON
+  snapshots <logDir>   show low-water mark and snapshots
+  tail <logDir>        print entries as they are appended to the log
+  verify <logDir>      scan all segments and report corruption
+
+run '%[1]s <command> -h' for command flags.
+`
+
+// stdout is where commands write their output.
+var stdout io.Writer = os.Stdout
+
+func main() {
+	prog := filepath.Base(os.Args[0])
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, usage, prog)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "segments":
+		err = segments(args)
+	case "dump":
+		err = dump(args)
+	case "snapshots":
+		err = snapshots(args)
+	case "tail":
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = tail(ctx, args)
+		stop()
+	case "verify":
+		err = verify(args)
+	default:
+		fmt.Fprintf(os.Stderr, usage, prog)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// zxidFlag is flag.Value for zxid given in decimal or as 0x-prefixed hex.
+type zxidFlag int64
+
+func (z *zxidFlag) String() string { return fmt.Sprintf("%#x", int64(*z)) }
+
+func (z *zxidFlag) Set(s string) error {
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	*z = zxidFlag(v)
+	return nil
+}
+
+// logDirArg parses flags of a command and returns its single log directory
+// argument.
+func logDirArg(fs *flag.FlagSet, args []string) (string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("%s: expected log directory", fs.Name())
+	}
+	return fs.Arg(0), nil
+}
+
+// formatTime formats entry time; zero time is printed as "-".
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// segments prints segments of the log with their zxid range, size and times.
+func segments(args []string) error {
+	fs := flag.NewFlagSet("segments", flag.ExitOnError)
+	logDir, err := logDirArg(fs, args)
+	if err != nil {
+		return err
+	}
+	segv, err := lowwatermark.ListSegments(logDir)
+	if err != nil {
+		return err
+	}
+
+	// SIZE is size of the entries, without space preallocated after them
+	fmt.Fprintln(stdout, "SEGMENT\tFIRST\tLAST\tENTRIES\tSIZE\tFIRST TIME\tLAST TIME")
+	for _, seg := range segv {
+		rep := lowwatermark.ScanSegment(seg)
+		fmt.Fprintf(stdout, "%s\t%#x\t%#x\t%d\t%d\t%s\t%s\n", filepath.Base(seg.Path),
+			rep.FirstZxid, rep.LastZxid, rep.Entries, rep.ValidSize,
+			formatTime(rep.FirstTime), formatTime(rep.LastTime))
+		if rep.Err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", rep.Err)
+		}
+	}
+	return nil
+}
+
+// entryPrinter prints log entries in chosen format.
+type entryPrinter struct {
+	format string // "text" or "json"
+	txn    bool   // whether to decode TxnHeader of entries
+	enc    *json.Encoder
+}
+
+func newEntryPrinter(format string, txn bool) (*entryPrinter, error) {
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	return &entryPrinter{format: format, txn: txn, enc: json.NewEncoder(stdout)}, nil
+}
+
+// jsonEntry is JSON form of a log entry. Data is base64-encoded.
+type jsonEntry struct {
+	Zxid   int64                   `json:"zxid"`
+	Time   time.Time               `json:"time"`
+	Header *lowwatermark.TxnHeader `json:"header,omitempty"`
+	Data   []byte                  `json:"data"`
+}
+
+func (p *entryPrinter) print(e *lowwatermark.TxnEntry) error {
+	var hdr *lowwatermark.TxnHeader
+	if p.txn {
+		var err error
+		hdr, err = lowwatermark.ReadTxn(e, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.format == "json" {
+		return p.enc.Encode(jsonEntry{Zxid: e.Zxid, Time: e.Time, Header: hdr, Data: e.Data})
+	}
+	fmt.Fprintf(stdout, "zxid=%#x time=%s len=%d", e.Zxid, formatTime(e.Time), len(e.Data))
+	if hdr != nil {
+		fmt.Fprintf(stdout, " session=%#x cxid=%#x type=%d", hdr.ClientId, hdr.Cxid, hdr.Type)
+	} else {
+		fmt.Fprintf(stdout, " data=%q", e.Data)
+	}
+	fmt.Fprintln(stdout)
+	return nil
+}
+
+// dump prints entries of the log in a zxid range.
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	from := zxidFlag(0)
+	to := zxidFlag(math.MaxInt64)
+	fs.Var(&from, "from", "first zxid to print")
+	fs.Var(&to, "to", "last zxid to print")
+	format := fs.String("format", "text", "output format: text or json")
+	txn := fs.Bool("txn", false, "decode entries as TxnHeader records")
+	logDir, err := logDirArg(fs, args)
+	if err != nil {
+		return err
+	}
+	p, err := newEntryPrinter(*format, *txn)
+	if err != nil {
+		return err
+	}
+
+	dbId, err := lowwatermark.LogDbId(logDir)
+	if err != nil {
+		return err
+	}
+	it, err := lowwatermark.OpenTxnIterator(logDir, dbId, int64(from))
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		e, err := it.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if e.Zxid > int64(to) {
+			return nil
+		}
+		err = p.print(e)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// snapshots prints low-water mark of the log and its snapshots.
+func snapshots(args []string) error {
+	fs := flag.NewFlagSet("snapshots", flag.ExitOnError)
+	logDir, err := logDirArg(fs, args)
+	if err != nil {
+		return err
+	}
+	snapv, err := lowwatermark.ListSnapshots(logDir)
+	if err != nil {
+		return err
+	}
+
+	if len(snapv) == 0 {
+		fmt.Fprintln(stdout, "low-water mark: none (no snapshots)")
+		return nil
+	}
+	fmt.Fprintf(stdout, "low-water mark: %#x\n", snapv[len(snapv)-1].Zxid)
+	fmt.Fprintln(stdout, "SNAPSHOT\tZXID\tSIZE\tMODIFIED")
+	for _, snap := range snapv {
+		modified := "-"
+		if info, err := os.Stat(snap.Path); err == nil {
+			modified = formatTime(info.ModTime())
+		}
+		fmt.Fprintf(stdout, "%s\t%#x\t%d\t%s\n", filepath.Base(snap.Path), snap.Zxid, snap.Size, modified)
+	}
+	return nil
+}
+
+// tail prints entries of the log as they are appended, until ctx is done.
+func tail(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	from := zxidFlag(-1)
+	fs.Var(&from, "from", "first zxid to print (default: only new entries)")
+	interval := fs.Duration("interval", 200*time.Millisecond, "how often to poll the log")
+	format := fs.String("format", "text", "output format: text or json")
+	txn := fs.Bool("txn", false, "decode entries as TxnHeader records")
+	logDir, err := logDirArg(fs, args)
+	if err != nil {
+		return err
+	}
+	p, err := newEntryPrinter(*format, *txn)
+	if err != nil {
+		return err
+	}
+
+	var dbId int64
+	for {
+		dbId, err = lowwatermark.LogDbId(logDir)
+		if err == nil {
+			break
+		}
+		// wait for the first segment to appear
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+
+	next := int64(from)
+	if next < 0 {
+		next, err = lastZxid(logDir, dbId)
+		if err != nil {
+			return err
+		}
+		next++
+	}
+	it, err := lowwatermark.OpenTxnIterator(logDir, dbId, next)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	// the iterator stays at the end of the log between polls and picks up
+	// new segments as the log rolls over
+	it.Follow()
+	for {
+		for {
+			e, err := it.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			err = p.print(e)
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// lastZxid returns zxid of the last entry in the log.
+func lastZxid(logDir string, dbId int64) (int64, error) {
+	segv, err := lowwatermark.ListSegments(logDir)
+	if err != nil {
+		return 0, err
+	}
+	if len(segv) == 0 {
+		return 0, fmt.Errorf("%s: no log segments", logDir)
+	}
+	it, err := lowwatermark.OpenTxnIterator(logDir, dbId, segv[len(segv)-1].Zxid)
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	last := segv[len(segv)-1].Zxid - 1
+	for {
+		e, err := it.Next()
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		last = e.Zxid
+	}
+}
+
+// verify scans log directory and prints a report line per segment.
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	logDir, err := logDirArg(fs, args)
+	if err != nil {
+		return err
+	}
+	reports, err := lowwatermark.VerifyLog(logDir)
+	if reports == nil && err != nil {
+		return err
+	}
+
+	for _, rep := range reports {
+		status := "ok"
+		switch {
+		case rep.Err != nil:
+			status = "CORRUPT: " + rep.Err.Error()
+		case rep.Torn:
+			status = fmt.Sprintf("torn tail: %d bytes after the last valid entry at offset %d (including preallocation)",
+				rep.Size-rep.ValidSize, rep.ValidSize)
+		}
+		zxids := "-"
+		if rep.Entries > 0 {
+			zxids = fmt.Sprintf("%#x..%#x", rep.FirstZxid, rep.LastZxid)
+		}
+		fmt.Fprintf(stdout, "%s\tdbId=%d\tentries=%d\tzxid=%s\tsize=%d\t%s\n",
+			filepath.Base(rep.Path), rep.DbId, rep.Entries, zxids, rep.Size, status)
+	}
+	fmt.Fprintf(stdout, "%d segments\n", len(reports))
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lowwatermark "github.com/zacksfF/Distributed-Systems-patterns/Low-Water-Mark"
+)
+
+// syncBuffer is bytes.Buffer safe to write from a running command while the
+// test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// run runs command with args and returns its output.
+func run(t *testing.T, cmd func([]string) error, args ...string) string {
+	t.Helper()
+	var out syncBuffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	err := cmd(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// appendN appends entries "txn <zxid>" to l until its last zxid is n, and
+// syncs it.
+func appendN(t *testing.T, l *lowwatermark.FileTxnLog, n int64) {
+	t.Helper()
+	for zxid := l.GetLastLoggedZxid() + 1; zxid <= n; zxid++ {
+		err := l.AppendEntry([]byte(fmt.Sprintf("txn %d", zxid)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := l.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeLog creates log in a temporary directory with entries 1..3 in one
+// segment and 4..5 in the next one.
+func writeLog(t *testing.T) (dir string, l *lowwatermark.FileTxnLog) {
+	t.Helper()
+	dir = t.TempDir()
+	l, err := lowwatermark.OpenFileTxnLog(dir, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	appendN(t, l, 3)
+	err = l.RollLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 5)
+	return dir, l
+}
+
+// wantLines fails the test unless out contains every line of want.
+func wantLines(t *testing.T, out string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Fatalf("output:\n%s\nmissing %q", out, w)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	dir, _ := writeLog(t)
+	out := run(t, segments, dir)
+	wantLines(t, out, "log.1\t0x1\t0x3\t3\t", "log.4\t0x4\t0x5\t2\t")
+}
+
+func TestDump(t *testing.T) {
+	dir, _ := writeLog(t)
+	out := run(t, dump, "-from", "2", "-to", "0x4", dir)
+	wantLines(t, out, `zxid=0x2 `, `data="txn 2"`, `zxid=0x4 `, `data="txn 4"`)
+	if strings.Contains(out, "zxid=0x1 ") || strings.Contains(out, "zxid=0x5 ") {
+		t.Fatalf("output:\n%s\nhas entries outside 2..4", out)
+	}
+
+	out = run(t, dump, "-format", "json", "-from", "5", dir)
+	wantLines(t, out, `"zxid":5`)
+}
+
+func TestSnapshots(t *testing.T) {
+	dir, l := writeLog(t)
+	out := run(t, snapshots, dir)
+	wantLines(t, out, "low-water mark: none")
+
+	err := l.TakeSnapshot(4, []byte("state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = run(t, snapshots, dir)
+	wantLines(t, out, "low-water mark: 0x4", "\t0x4\t")
+}
+
+func TestVerify(t *testing.T) {
+	dir, l := writeLog(t)
+	out := run(t, verify, dir)
+	wantLines(t, out, "log.1\tdbId=7\tentries=3\tzxid=0x1..0x3\t", "2 segments")
+
+	// garbage after the last entry of the active segment is a torn tail
+	segv, err := lowwatermark.ListSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := segv[len(segv)-1]
+	end := lowwatermark.ScanSegment(last).ValidSize
+	f, err := os.OpenFile(last.Path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 100}, end)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	out = run(t, verify, dir)
+	wantLines(t, out, fmt.Sprintf("after the last valid entry at offset %d", end))
+}
+
+func TestTail(t *testing.T) {
+	dir, l := writeLog(t)
+
+	var out syncBuffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- tail(ctx, []string{"-from", "4", "-interval", "10ms", dir})
+	}()
+
+	// waitFor waits until tail prints entry with zxid
+	waitFor := func(zxid int64) {
+		t.Helper()
+		want := fmt.Sprintf("zxid=%#x ", zxid)
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(out.String(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("tail output:\n%s\nmissing %q", out.String(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor(5)
+
+	// entries appended to the active segment and after rollover
+	appendN(t, l, 6)
+	waitFor(6)
+	err := l.RollLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, l, 8)
+	waitFor(8)
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if strings.Contains(got, "zxid=0x3 ") || strings.Count(got, "zxid=0x7 ") != 1 {
+		t.Fatalf("tail output:\n%s", got)
+	}
+}
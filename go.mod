@@ -1,3 +1,8 @@
 module github.com/zacksfF/Distributed-Systems-patterns
 
 go 1.22.2
+
+require (
+	golang.org/x/crypto v0.31.0
+	google.golang.org/protobuf v1.34.2
+)
@@ -0,0 +1,254 @@
+package messagepassing
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errUnknownClient is returned by authenticators for clients they have no
+// credentials for.
+var errUnknownClient = errors.New("unknown client")
+
+// Authenticator verifies identity of clients connecting to a Server.
+//
+// A client starts authentication with a MetaAuth message. If the
+// authenticator has a challenge for the client, the server sends it in
+// MetaAuthChallenge and the client proves its identity with a second MetaAuth
+// message answering the challenge; otherwise the credentials are the data of
+// the first MetaAuth message.
+type Authenticator interface {
+	// Challenge returns challenge for client name, or nil if the client
+	// sends its credentials right away.
+	Challenge(name string) ([]byte, error)
+	// Verify checks credentials of client name given in answer to
+	// challenge, which is nil if there was none.
+	Verify(name string, challenge, credentials []byte) error
+}
+
+// Authorizer decides which clients may open connections to which.
+type Authorizer interface {
+	// AllowConnection reports whether client from may open a connection
+	// to client to.
+	AllowConnection(from, to string) bool
+}
+
+// readCredentialsFile reads file of "name:secret" lines. Empty lines and lines
+// starting with '#' are ignored.
+func readCredentialsFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	creds := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, secret, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected name:secret", path, lineno)
+		}
+		if _, dup := creds[name]; dup {
+			return nil, fmt.Errorf("%s:%d: duplicate client %q", path, lineno, name)
+		}
+		creds[name] = secret
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// StaticAuthenticator authenticates clients by plain passwords.
+type StaticAuthenticator struct {
+	passwords map[string][]byte
+}
+
+// NewStaticAuthenticator creates StaticAuthenticator for clients with
+// passwords given by name.
+func NewStaticAuthenticator(passwords map[string]string) *StaticAuthenticator {
+	a := &StaticAuthenticator{passwords: make(map[string][]byte, len(passwords))}
+	for name, password := range passwords {
+		a.passwords[name] = []byte(password)
+	}
+	return a
+}
+
+// LoadStaticAuthenticator reads StaticAuthenticator from file of
+// "name:password" lines.
+func LoadStaticAuthenticator(path string) (*StaticAuthenticator, error) {
+	passwords, err := readCredentialsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticAuthenticator(passwords), nil
+}
+
+// Challenge implements Authenticator; clients send passwords right away.
+func (a *StaticAuthenticator) Challenge(name string) ([]byte, error) {
+	return nil, nil
+}
+
+// Verify implements Authenticator.
+func (a *StaticAuthenticator) Verify(name string, challenge, password []byte) error {
+	want, ok := a.passwords[name]
+	if !ok {
+		return errUnknownClient
+	}
+	if subtle.ConstantTimeCompare(password, want) != 1 {
+		return errors.New("wrong password")
+	}
+	return nil
+}
+
+// BcryptAuthenticator authenticates clients by passwords checked against
+// their bcrypt hashes, so that the server does not store the passwords.
+type BcryptAuthenticator struct {
+	hashes map[string][]byte
+}
+
+// NewBcryptAuthenticator creates BcryptAuthenticator for clients with
+// password hashes given by name.
+func NewBcryptAuthenticator(hashes map[string]string) (*BcryptAuthenticator, error) {
+	a := &BcryptAuthenticator{hashes: make(map[string][]byte, len(hashes))}
+	for name, hash := range hashes {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("client %q: %w", name, err)
+		}
+		a.hashes[name] = []byte(hash)
+	}
+	return a, nil
+}
+
+// LoadBcryptAuthenticator reads BcryptAuthenticator from file of
+// "name:hash" lines, such as written by htpasswd -B.
+func LoadBcryptAuthenticator(path string) (*BcryptAuthenticator, error) {
+	hashes, err := readCredentialsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a, err := NewBcryptAuthenticator(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+// Challenge implements Authenticator; clients send passwords right away.
+func (a *BcryptAuthenticator) Challenge(name string) ([]byte, error) {
+	return nil, nil
+}
+
+// Verify implements Authenticator.
+func (a *BcryptAuthenticator) Verify(name string, challenge, password []byte) error {
+	hash, ok := a.hashes[name]
+	if !ok {
+		return errUnknownClient
+	}
+	return bcrypt.CompareHashAndPassword(hash, password)
+}
+
+// hmacChallengeSize is size of random challenges sent by HMACAuthenticator.
+const hmacChallengeSize = 32
+
+// HMACAuthenticator authenticates clients by challenge-response: the client
+// answers a random challenge with HMAC-SHA256 of it keyed by a secret shared
+// with the server, so the secret is never sent. Clients authenticate with
+// Client.AuthenticateChallenge.
+type HMACAuthenticator struct {
+	secrets map[string][]byte
+}
+
+// NewHMACAuthenticator creates HMACAuthenticator for clients with secrets
+// given by name.
+func NewHMACAuthenticator(secrets map[string]string) *HMACAuthenticator {
+	a := &HMACAuthenticator{secrets: make(map[string][]byte, len(secrets))}
+	for name, secret := range secrets {
+		a.secrets[name] = []byte(secret)
+	}
+	return a
+}
+
+// LoadHMACAuthenticator reads HMACAuthenticator from file of "name:secret"
+// lines.
+func LoadHMACAuthenticator(path string) (*HMACAuthenticator, error) {
+	secrets, err := readCredentialsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewHMACAuthenticator(secrets), nil
+}
+
+// Challenge implements Authenticator.
+//
+// Unknown clients are challenged as well, not to tell them apart from known
+// ones.
+func (a *HMACAuthenticator) Challenge(name string) ([]byte, error) {
+	challenge := make([]byte, hmacChallengeSize)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// Verify implements Authenticator.
+func (a *HMACAuthenticator) Verify(name string, challenge, response []byte) error {
+	secret, ok := a.secrets[name]
+	if !ok {
+		return errUnknownClient
+	}
+	if len(challenge) != hmacChallengeSize {
+		return errors.New("no challenge was sent")
+	}
+	if !hmac.Equal(response, ChallengeResponse(secret, name, challenge)) {
+		return errors.New("wrong challenge response")
+	}
+	return nil
+}
+
+// ChallengeResponse returns answer of client name with secret to
+// challenge of HMACAuthenticator.
+//
+// The name is part of the MAC, so a response cannot be replayed by another
+// client sharing the secret.
+func ChallengeResponse(secret []byte, name string, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(challenge)
+	mac.Write([]byte(name))
+	return mac.Sum(nil)
+}
+
+// ConnectionRules is Authorizer allowing each client, by name, to open
+// connections only to the listed clients.
+//
+// The name "*" matches any client, both as a key and in a list; clients with
+// no rule and no "*" rule may not open connections at all.
+type ConnectionRules map[string][]string
+
+// AllowConnection implements Authorizer.
+func (r ConnectionRules) AllowConnection(from, to string) bool {
+	allowed, ok := r[from]
+	if !ok {
+		allowed = r["*"]
+	}
+	for _, name := range allowed {
+		if name == to || name == "*" {
+			return true
+		}
+	}
+	return false
+}
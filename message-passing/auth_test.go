@@ -0,0 +1,153 @@
+package messagepassing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeCredentials writes credentials file with lines in a test directory.
+func writeCredentials(t *testing.T, lines string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials")
+	err := os.WriteFile(path, []byte(lines), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStaticAuthenticatorFile(t *testing.T) {
+	path := writeCredentials(t, "# clients\nalice:open:sesame\n\nbob:hunter2\n")
+	auth, err := LoadStaticAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(NewGobTranslator, auth)
+	defer s.Close()
+
+	c := NewClient("alice", dial(s), NewGobTranslator, NewConnectionHandler())
+	defer c.Close()
+	if err := c.Authenticate([]byte("open:sesame")); err != nil {
+		t.Fatalf("alice: %v", err)
+	}
+	c = NewClient("bob", dial(s), NewGobTranslator, NewConnectionHandler())
+	defer c.Close()
+	if err := c.Authenticate([]byte("open:sesame")); err == nil {
+		t.Fatal("bob: wrong password was accepted")
+	}
+
+	if _, err := LoadStaticAuthenticator(writeCredentials(t, "alice\n")); err == nil {
+		t.Fatal("line without password was accepted")
+	}
+}
+
+func TestBcryptAuthenticator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := LoadBcryptAuthenticator(writeCredentials(t, "bob:"+string(hash)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Verify("bob", nil, []byte("hunter2")); err != nil {
+		t.Fatalf("right password: %v", err)
+	}
+	for _, tt := range []struct{ name, password string }{
+		{"bob", "hunter3"},
+		{"alice", "hunter2"},
+	} {
+		if err := auth.Verify(tt.name, nil, []byte(tt.password)); err == nil {
+			t.Errorf("%s:%s was accepted", tt.name, tt.password)
+		}
+	}
+
+	if _, err := NewBcryptAuthenticator(map[string]string{"bob": "hunter2"}); err == nil {
+		t.Fatal("plain password was accepted as hash")
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	s := NewServer(NewGobTranslator, NewHMACAuthenticator(map[string]string{"alice": "k3y"}))
+	defer s.Close()
+
+	// the secret is never sent, so a sniffed response is no good for
+	// another challenge
+	conn := dial(s)
+	defer conn.Close()
+	tr := NewGobTranslator(conn, conn)
+	err := tr.WriteMessage(&Message{Meta: MetaAuth, OtherClient: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := tr.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Meta != MetaAuthChallenge || len(challenge.Data) != hmacChallengeSize {
+		t.Fatalf("challenge: got %+v", challenge)
+	}
+	err = tr.WriteMessage(&Message{Meta: MetaAuth, OtherClient: "alice", Data: []byte("k3y")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := tr.ReadMessage(); err != nil || resp.Meta != MetaauthFailure {
+		t.Fatalf("secret sent as response: got %+v, %v; want MetaauthFailure", resp, err)
+	}
+
+	c := NewClient("alice", dial(s), NewGobTranslator, NewConnectionHandler())
+	defer c.Close()
+	if err := c.Authenticate([]byte("k3y")); err == nil || err.Error() != errStringChallengeRequired {
+		t.Fatalf("password: err = %v; want %q", err, errStringChallengeRequired)
+	}
+	c = NewClient("alice", dial(s), NewGobTranslator, NewConnectionHandler())
+	defer c.Close()
+	if err := c.AuthenticateChallenge([]byte("wrong")); err == nil {
+		t.Fatal("wrong secret was accepted")
+	}
+	c = NewClient("alice", dial(s), NewGobTranslator, NewConnectionHandler())
+	defer c.Close()
+	if err := c.AuthenticateChallenge([]byte("k3y")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectionRules(t *testing.T) {
+	rules := ConnectionRules{
+		"alice": {"bob"},
+		"*":     {"alice"},
+	}
+	for _, tt := range []struct {
+		from, to string
+		want     bool
+	}{
+		{"alice", "bob", true},
+		{"alice", "carol", false},
+		{"bob", "alice", true},
+		{"carol", "alice", true},
+		{"carol", "bob", false},
+	} {
+		if got := rules.AllowConnection(tt.from, tt.to); got != tt.want {
+			t.Errorf("%s -> %s: allowed = %v; want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	s := newTestServer(t, NewGobTranslator)
+	s.SetAuthorizer(rules)
+	alice := startClient(t, s, NewGobTranslator, "alice", echoHandler())
+	bob := startClient(t, s, NewGobTranslator, "bob", echoHandler())
+	mallory := startClient(t, s, NewGobTranslator, "mallory", nil)
+
+	if _, err := alice.MakeConnection("bob", "echo"); err != nil {
+		t.Fatalf("alice -> bob: %v", err)
+	}
+	if _, err := bob.MakeConnection("alice", "echo"); err != nil {
+		t.Fatalf("bob -> alice: %v", err)
+	}
+	if _, err := mallory.MakeConnection("bob", "echo"); err == nil {
+		t.Fatal("mallory -> bob: connection was allowed")
+	}
+}
@@ -23,6 +23,8 @@ type Client struct {
 	connections    map[string]*ClientConnections
 	connectionLock sync.Mutex
 
+	flow FlowControl
+
 	authed bool
 }
 
@@ -32,21 +34,40 @@ type ClientConnections struct {
 
 	closed chan struct{}
 
+	// handshake receives reply to our MetaConnSyn, for MakeConnection
+	handshake chan []byte
+
 	message         chan []byte
 	currentMessaage []byte
 	readLock        sync.Mutex
 
-	OtherClient string
+	window      int    // size of message buffer, granted to the other end
+	consumed    uint32 // messages read since last window update
+	nonBlocking bool
+	credit      int // messages we may still send
+	creditLock  sync.Mutex
+	creditAvail chan struct{}
+
+	otherClient string
 	connId      string
 	client      *Client
 }
 
 func newClientConnection(otherClient, connID string, client *Client) *ClientConnections {
+	flow := client.flow
+	if flow.Window < 1 {
+		flow.Window = DefaultWindow
+	}
 	return &ClientConnections{
-		//message needs to remain unbuffered because of how our closedd channel works.
-		message:     make(chan []byte),
+		// the other end never sends more than the window we granted, so
+		// delivering a message never blocks
+		message:     make(chan []byte, flow.Window),
+		handshake:   make(chan []byte, 1),
 		closed:      make(chan struct{}),
-		OtherClient: otherClient,
+		window:      flow.Window,
+		nonBlocking: flow.NonBlocking,
+		creditAvail: make(chan struct{}, 1),
+		otherClient: otherClient,
 		connId:      connID,
 		client:      client,
 	}
@@ -57,9 +78,10 @@ var (
 )
 
 const (
-	errSTringUnknownProtocol = "unknown protocol "
-	errSTringMultuipleAuths  = "client has already authenticated"
-	errStringNotYetAuthed    = "client not yet authenticated"
+	errSTringUnknownProtocol   = "unknown protocol "
+	errSTringMultuipleAuths    = "client has already authenticated"
+	errStringNotYetAuthed      = "client not yet authenticated"
+	errStringChallengeRequired = "server requires challenge-response authentication"
 )
 
 const (
@@ -71,43 +93,69 @@ func (c *ClientConnections) ReadIntoBuffer(blocking bool) error {
 		return nil
 	}
 	var msg []byte
-	if !blocking {
-		select {
-		case msg = <-c.message:
-			break
-		case <-c.closed:
-			return io.EOF
-		default:
-			return errWouldBlock
+	// messages buffered before the connection was closed are read first
+	select {
+	case msg = <-c.message:
+	default:
+		if !blocking {
+			select {
+			case <-c.closed:
+				return io.EOF
+			default:
+				return errWouldBlock
+			}
 		}
-	} else {
 		select {
 		case msg = <-c.message:
-			break
 		case <-c.closed:
-			return io.EOF
+			select {
+			case msg = <-c.message:
+			default:
+				return io.EOF
+			}
 		}
 	}
+	c.noteConsumed()
 	c.currentMessaage = msg
 	return nil
 }
 
 func (c *ClientConnections) IshanshakeComplete() bool {
-	return atomic.LoadInt32(&c.isEstablished) != 0
+	return atomic.LoadUint32(&c.isEstablished) != 0
 }
 
-func (c *Client) noteHandshakeComplete() {
+func (c *ClientConnections) noteHandshakeComplete() {
 	atomic.StoreUint32(&c.isEstablished, 1)
 }
 
+// handshakeResult returns c if the other client accepted the connection, or
+// the reason it was rejected with.
+func (c *ClientConnections) handshakeResult(data []byte) (Connection, error) {
+	if !c.IshanshakeComplete() {
+		return nil, errors.New(string(data))
+	}
+	return c, nil
+}
+
+// Putmessage delivers data of msg to the reader of the connection. It never
+// blocks: if the other end sent more than the window it was granted, the
+// connection is closed.
 func (c *ClientConnections) Putmessage(msg *Message) bool {
 	select {
 	case <-c.closed:
 		return false
+	default:
+	}
+	select {
 	case c.message <- msg.Data:
 		return true
+	default:
+		if client := c.client; client != nil {
+			client.removeConnection(c.connId)
+		}
+		c.CloseNotify()
+		return false
 	}
-	panic(unreachableCode)
 }
 
 func (c *ClientConnections) Read(b []byte) (int, error) {
@@ -144,7 +192,7 @@ func (c *ClientConnections) Write(b []byte) (int, error) {
 }
 
 func (c *ClientConnections) Close() error {
-	if atomic.CompareAndSwapInt32(&c.isCLosed, 0, 1) {
+	if atomic.CompareAndSwapUint32(&c.isCLosed, 0, 1) {
 		close(c.closed)
 		//nil check beacuase testing can nil out the client
 		if client := c.client; client != nil {
@@ -155,7 +203,7 @@ func (c *ClientConnections) Close() error {
 }
 
 func (c *ClientConnections) CloseNotify() error {
-	if atomic.CompareAndSwapInt32(&c.isCLosed, 0, 1) {
+	if atomic.CompareAndSwapUint32(&c.isCLosed, 0, 1) {
 		close(c.closed)
 	}
 	return nil
@@ -176,14 +224,19 @@ func (c *ClientConnections) ReadMessage() ([]byte, error) {
 }
 
 func (c *ClientConnections) WriteMessage(b []byte) error {
+	err := c.takeCredit()
+	if err != nil {
+		return err
+	}
+
 	msg := Message{
 		Meta:         MetaNone,
-		OtherClient:  c.OtherClient,
+		OtherClient:  c.otherClient,
 		ConnectionID: c.connId,
 		Data:         b,
 	}
 
-	err := c.client.sendMessage(&msg)
+	err = c.client.sendMessage(&msg)
 	if err != nil {
 		return err
 	}
@@ -191,7 +244,7 @@ func (c *ClientConnections) WriteMessage(b []byte) error {
 }
 
 func (c *ClientConnections) OtherClient() string {
-	return c.OtherClient
+	return c.otherClient
 }
 
 func NewClient(
@@ -250,11 +303,50 @@ func (c *Client) Authenticate(password []byte) error {
 	if err != nil {
 		return err
 	}
+	if resp.Meta == MetaAuthChallenge {
+		return errors.New(errStringChallengeRequired)
+	}
+	return c.authResult(resp)
+}
 
-	if resp.Meta != MetaAuth {
-		return errors.New(string(resp.Data))
+// AuthenticateChallenge is like Authenticate, but for servers that challenge
+// clients: the client answers the challenge with ChallengeResponse keyed by
+// secret and never sends the secret itself.
+func (c *Client) AuthenticateChallenge(secret []byte) error {
+	if c.authed {
+		return errors.New(errSTringMultuipleAuths)
 	}
 
+	err := c.sendMessage(&Message{Meta: MetaAuth, OtherClient: c.name})
+	if err != nil {
+		return err
+	}
+	resp, err := c.recvMessage()
+	if err != nil {
+		return err
+	}
+	if resp.Meta == MetaAuthChallenge {
+		err = c.sendMessage(&Message{
+			Meta:        MetaAuth,
+			OtherClient: c.name,
+			Data:        ChallengeResponse(secret, c.name, resp.Data),
+		})
+		if err != nil {
+			return err
+		}
+		resp, err = c.recvMessage()
+		if err != nil {
+			return err
+		}
+	}
+	return c.authResult(resp)
+}
+
+// authResult marks the client authenticated if resp accepts it.
+func (c *Client) authResult(resp *Message) error {
+	if resp.Meta != MetaAuthOk {
+		return errors.New(string(resp.Data))
+	}
 	c.authed = true
 	return nil
 }
@@ -272,7 +364,7 @@ func (c *Client) NextConnIDNonAtomic() string {
 }
 
 func (c *Client) MakeAndAddClientConn(otherClient string) (conn *ClientConnections, id string) {
-	c.connectionLock()
+	c.connectionLock.Lock()
 	defer c.connectionLock.Unlock()
 
 	for {
@@ -303,11 +395,19 @@ func (c *Client) MakeConnection(otherClient, proto string) (Connection, error) {
 		return nil, err
 	}
 
-	data, err := conn.ReadMessage()
-	if err != nil {
-		return nil, errors.New(string(data))
+	select {
+	case data := <-conn.handshake:
+		return conn.handshakeResult(data)
+	case <-conn.closed:
+		// a rejection is delivered right before the connection is closed
+		select {
+		case data := <-conn.handshake:
+			return conn.handshakeResult(data)
+		default:
+		}
+		// the server closed the connection with MetaNoSuchConnection
+		return nil, fmt.Errorf("no such client %q", otherClient)
 	}
-	return conn, nil
 }
 
 func (c *Client) findAnyConnection(id string) (*ClientConnections, bool) {
@@ -359,7 +459,9 @@ func (c *Client) MetaHandleSyn(msg *Message, proto string) (resp bool, er error)
 		c.connections[msg.ConnectionID] = conn
 		c.connectionLock.Unlock()
 
+		// the ACK grants the other end our window
 		msg.Meta = MetaConnACk
+		msg.Data = encodeWindow(conn.window)
 
 		_ = c.sendMessage(msg)
 
@@ -420,8 +522,10 @@ func (c *Client) handleMetaMessage(msg *Message) (resp bool, err error) {
 			// (It's also expected that we'll never get MetaUnknownProto if our
 			// handshake is complete, but the extra protection doesn't hurt)
 			if !conn.IshanshakeComplete() {
-				msg.Data = []byte(errSTringUnknownProtocol)
-				conn.Putmessage(msg)
+				select {
+				case conn.handshake <- []byte(errSTringUnknownProtocol):
+				default:
+				}
 			}
 			conn.CloseNotify()
 		}
@@ -437,9 +541,23 @@ func (c *Client) handleMetaMessage(msg *Message) (resp bool, err error) {
 			msg.Meta = MetaNoSuchConnection
 			return true, nil
 		}
+		if conn.IshanshakeComplete() {
+			return false, nil
+		}
 
+		// credit has to be there before MakeConnection returns
+		conn.grant(decodeWindow(msgData))
 		conn.noteHandshakeComplete()
-		conn.Putmessage(msg)
+		conn.handshake <- nil
+
+		msg.Meta = MetaWindowUpdate
+		msg.Data = encodeWindow(conn.window)
+		return true, nil
+	case MetaWindowUpdate:
+		conn, ok := c.FindEstablishedConnection(msg.ConnectionID)
+		if ok {
+			conn.grant(decodeWindow(msgData))
+		}
 		return false, nil
 	case MetaClientCLosed:
 		otherClient := msg.OtherClient
@@ -457,16 +575,17 @@ func (c *Client) handleMetaMessage(msg *Message) (resp bool, err error) {
 		}
 		c.connectionLock.Unlock()
 		return false, nil
-	case MetaAuth, MetaAuthOk, MetaauthFailure:
+	case MetaWAT:
+		// the server did not understand something we sent; there is
+		// nothing to answer
+		return false, nil
+	case MetaAuth, MetaAuthOk, MetaauthFailure, MetaAuthChallenge:
 		msg.Meta = MetaWAT
 		return true, nil
 	default:
 		s := fmt.Sprintf("Unknown meta message type passed into handleMetaMessage: %d", msg.Meta)
 		return false, errors.New(s)
 	}
-
-	// compat with Go 1.0
-	panic(unreachableCode)
 }
 
 func (c *Client) notifyClosed(conn *ClientConnections) error {
@@ -477,7 +596,7 @@ func (c *Client) notifyClosed(conn *ClientConnections) error {
 
 	closedMsg := &Message{
 		Meta:         MetaConnClosed,
-		OtherClient:  conn.OtherClient,
+		OtherClient:  conn.otherClient,
 		ConnectionID: conn.connId,
 	}
 
@@ -523,6 +642,4 @@ func (c *Client) Run() error {
 			}
 		}
 	}
-	// compat with Go 1.0
-	panic(unreachableCode)
 }
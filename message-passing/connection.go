@@ -27,6 +27,10 @@ const (
 	MetaAuth
 	MetaAuthOk
 	MetaauthFailure
+	MetaAuthChallenge //Challenge the client has to answer to authenticate
+	MetaWindowUpdate  //Credit for more messages granted by the reader of a connection
+
+	numMetaTypes // keep last; message.proto has to list all meta types
 )
 
 type Connection interface {
@@ -40,7 +44,7 @@ type Connection interface {
 	WriteMessage([]byte) error
 
 	//Gets the name of the client that our other Connection resides on.
-	OtherClient(string, error)
+	OtherClient() string
 }
 
 // NewConnectionhandlers is an interface that client call when a request for
@@ -50,6 +54,10 @@ type NewConnectionhandler interface {
 	IncomingConnection(proto string, accept func() Connection)
 }
 
+// TranslatorMaker creates a MessageTranslator that reads messages from r and
+// writes them to w.
+type TranslatorMaker func(r io.Reader, w io.Writer) MessageTranslator
+
 // MessageTranslator is type that can read a message from a Reader and write
 // a message to a writer in some format.
 type MessageTranslator interface {
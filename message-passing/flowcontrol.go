@@ -0,0 +1,122 @@
+package messagepassing
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// DefaultWindow is number of messages a connection buffers for its reader
+// unless the Client is configured otherwise.
+const DefaultWindow = 64
+
+// ErrWindowFull is returned by writes to a connection of a non-blocking
+// Client when the other end has not granted credit for more messages.
+var ErrWindowFull = errors.New("connection window is full")
+
+// FlowControl configures credit-based flow control of a Client's connections.
+//
+// Each end of a connection grants the other credit for as many messages as
+// it has buffered for its reader, and grants more with MetaWindowUpdate as
+// the reader consumes them. A writer that runs out of credit waits for more,
+// so a slow reader holds back only its own connection and Client.Run never
+// blocks on delivering a message.
+type FlowControl struct {
+	// Window is number of messages buffered for the reader of every
+	// connection. Values below 1 mean DefaultWindow.
+	Window int
+	// NonBlocking makes writes fail with ErrWindowFull instead of waiting
+	// for credit. The initial credit of the end that accepted the
+	// connection arrives just after the connection is established, so its
+	// first writes may fail as well.
+	NonBlocking bool
+}
+
+// SetFlowControl configures flow control of connections made afterwards. It
+// should be called before Client.Run().
+func (c *Client) SetFlowControl(fc FlowControl) {
+	c.flow = fc
+}
+
+// encodeWindow returns Data of a message granting n messages of credit.
+func encodeWindow(n int) []byte {
+	return binary.AppendUvarint(nil, uint64(n))
+}
+
+// decodeWindow returns credit granted by Data of a message, or 0 if there is
+// none.
+func decodeWindow(data []byte) int {
+	n, k := binary.Uvarint(data)
+	if k <= 0 || n > uint64(1<<31-1) {
+		return 0
+	}
+	return int(n)
+}
+
+// grant adds n messages to credit of the connection.
+func (c *ClientConnections) grant(n int) {
+	if n == 0 {
+		return
+	}
+	c.creditLock.Lock()
+	c.credit += n
+	c.creditLock.Unlock()
+	c.signalCredit()
+}
+
+// signalCredit wakes up a writer waiting for credit.
+func (c *ClientConnections) signalCredit() {
+	select {
+	case c.creditAvail <- struct{}{}:
+	default:
+	}
+}
+
+// takeCredit takes credit for one message, waiting for it unless the client
+// is non-blocking.
+func (c *ClientConnections) takeCredit() error {
+	for {
+		c.creditLock.Lock()
+		if c.credit > 0 {
+			c.credit--
+			left := c.credit
+			c.creditLock.Unlock()
+			if left > 0 {
+				// pass the signal on to other waiting writers
+				c.signalCredit()
+			}
+			return nil
+		}
+		c.creditLock.Unlock()
+
+		if c.nonBlocking {
+			return ErrWindowFull
+		}
+		select {
+		case <-c.creditAvail:
+		case <-c.closed:
+			return io.ErrClosedPipe
+		}
+	}
+}
+
+// noteConsumed records that the reader took a message out of the buffer and
+// grants the other end credit for the freed space once half of the window is
+// free, not to send an update for every message.
+func (c *ClientConnections) noteConsumed() {
+	n := atomic.AddUint32(&c.consumed, 1)
+	if int(n) < (c.window+1)/2 || !atomic.CompareAndSwapUint32(&c.consumed, n, 0) {
+		return
+	}
+	if c.client == nil {
+		return
+	}
+	// an error means the connection to the server is gone; Run notices
+	_ = c.client.sendMessage(&Message{
+		Meta:         MetaWindowUpdate,
+		OtherClient:  c.otherClient,
+		ConnectionID: c.connId,
+		Data:         encodeWindow(int(n)),
+	})
+}
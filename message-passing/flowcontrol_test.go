@@ -0,0 +1,134 @@
+package messagepassing
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// startStuckPeer runs client bob with window, accepting "stuck" connections
+// that nobody reads and "echo" connections.
+func startStuckPeer(t *testing.T, s *Server, window int) <-chan Connection {
+	t.Helper()
+	stuck := make(chan Connection, 1)
+	h := echoHandler()
+	h.AddMapping("stuck", func(conn Connection) { stuck <- conn })
+	bob := NewClient("bob", dial(s), NewGobTranslator, h)
+	bob.SetFlowControl(FlowControl{Window: window})
+	runClient(t, bob, "bob")
+	return stuck
+}
+
+func TestStuckReaderDoesNotStarveOthers(t *testing.T) {
+	const window, total = 4, 20
+	s := newTestServer(t, NewGobTranslator)
+	alice := startClient(t, s, NewGobTranslator, "alice", nil)
+	stuck := startStuckPeer(t, s, window)
+
+	stuckConn, err := alice.MakeConnection("bob", "stuck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written atomic.Int32
+	writeErr := make(chan error, 1)
+	go func() {
+		for i := 0; i < total; i++ {
+			err := stuckConn.WriteMessage([]byte(fmt.Sprint(i)))
+			if err != nil {
+				writeErr <- err
+				return
+			}
+			written.Add(1)
+		}
+		writeErr <- nil
+	}()
+	waitFor(t, "writes up to the window", func() bool { return written.Load() == window })
+
+	// bob's Run keeps serving other connections while nobody reads the
+	// stuck one
+	echoConn, err := alice.MakeConnection("bob", "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*window; i++ {
+		err = echoConn.WriteMessage([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg, err := readTimeout(t, echoConn); err != nil || string(msg) != "ping" {
+			t.Fatalf("echo: got %q, %v", msg, err)
+		}
+	}
+	if n := written.Load(); n != window {
+		t.Fatalf("writer wrote %d messages past window of %d", n, window)
+	}
+
+	var reader Connection
+	select {
+	case reader = <-stuck:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stuck connection was not accepted")
+	}
+	for i := 0; i < total; i++ {
+		msg, err := readTimeout(t, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != fmt.Sprint(i) {
+			t.Fatalf("message %d: got %q", i, msg)
+		}
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNonBlockingWindow(t *testing.T) {
+	const window = 4
+	s := newTestServer(t, NewGobTranslator)
+	alice := NewClient("alice", dial(s), NewGobTranslator, NewConnectionHandler())
+	alice.SetFlowControl(FlowControl{NonBlocking: true})
+	runClient(t, alice, "alice")
+	stuck := startStuckPeer(t, s, window)
+
+	conn, err := alice.MakeConnection("bob", "stuck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < window; i++ {
+		if err := conn.WriteMessage([]byte("x")); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if err := conn.WriteMessage([]byte("x")); !errors.Is(err, ErrWindowFull) {
+		t.Fatalf("write past window: err = %v; want ErrWindowFull", err)
+	}
+
+	// reading half of the window grants credit for it
+	reader := <-stuck
+	for i := 0; i < window/2; i++ {
+		if _, err := readTimeout(t, reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "window update", func() bool {
+		err := conn.WriteMessage([]byte("x"))
+		if err != nil && !errors.Is(err, ErrWindowFull) {
+			t.Fatal(err)
+		}
+		return err == nil
+	})
+}
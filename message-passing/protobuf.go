@@ -0,0 +1,159 @@
+package messagepassing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of Message in message.proto.
+const (
+	protoFieldMeta         protowire.Number = 1
+	protoFieldOtherClient  protowire.Number = 2
+	protoFieldConnectionID protowire.Number = 3
+	protoFieldData         protowire.Number = 4
+)
+
+var errInvalidUTF8 = errors.New("protobuf string field is not valid UTF-8")
+
+// Translates messages to length-delimited protobuf frames of Message defined
+// in message.proto, so that clients in other languages can use code generated
+// from the schema. Fields with default values are omitted and unknown fields
+// are skipped, as protobuf requires.
+type protobufTranslator struct {
+	r            *bufio.Reader
+	w            io.Writer
+	maxFrameSize int
+}
+
+// NewProtobufTranslator creates a new MessageTranslator that reads/writes
+// messages as length-delimited protobuf of at most DefaultMaxFrameSize
+// bytes.
+func NewProtobufTranslator(r io.Reader, w io.Writer) MessageTranslator {
+	return &protobufTranslator{bufio.NewReader(r), w, DefaultMaxFrameSize}
+}
+
+// ProtobufTranslatorMaker returns TranslatorMaker for protobuf translators
+// with frames of at most maxFrameSize bytes.
+func ProtobufTranslatorMaker(maxFrameSize int) TranslatorMaker {
+	return func(r io.Reader, w io.Writer) MessageTranslator {
+		return &protobufTranslator{bufio.NewReader(r), w, maxFrameSize}
+	}
+}
+
+func (t *protobufTranslator) WriteMessage(m *Message) error {
+	if !utf8.ValidString(m.OtherClient) || !utf8.ValidString(m.ConnectionID) {
+		return errInvalidUTF8
+	}
+
+	bp := getFrameBuffer()
+	defer putFrameBuffer(bp)
+	// room for the size prefix, moved next to the message once it is known
+	const prefixRoom = binary.MaxVarintLen64
+	b := append(*bp, make([]byte, prefixRoom)...)
+	if m.Meta != MetaNone {
+		b = protowire.AppendTag(b, protoFieldMeta, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.Meta))
+	}
+	if m.OtherClient != "" {
+		b = protowire.AppendTag(b, protoFieldOtherClient, protowire.BytesType)
+		b = protowire.AppendString(b, m.OtherClient)
+	}
+	if m.ConnectionID != "" {
+		b = protowire.AppendTag(b, protoFieldConnectionID, protowire.BytesType)
+		b = protowire.AppendString(b, m.ConnectionID)
+	}
+	// Data is written as is after its tag and length
+	var dataSize int
+	if len(m.Data) > 0 {
+		b = protowire.AppendTag(b, protoFieldData, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(len(m.Data)))
+		dataSize = len(m.Data)
+	}
+	*bp = b
+
+	size := len(b) - prefixRoom + dataSize
+	if size > t.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	start := prefixRoom - protowire.SizeVarint(uint64(size))
+	protowire.AppendVarint(b[start:start], uint64(size))
+
+	if dataSize == 0 {
+		_, err := t.w.Write(b[start:])
+		return err
+	}
+	bufs := net.Buffers{b[start:], m.Data}
+	_, err := bufs.WriteTo(t.w)
+	return err
+}
+
+func (t *protobufTranslator) ReadMessage() (*Message, error) {
+	size, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(t.maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	// Data aliases the frame instead of being copied out of it
+	frame := make([]byte, size)
+	_, err = io.ReadFull(t.r, frame)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{}
+	for b := frame; len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == protoFieldMeta && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			if n >= 0 && v > 0xff {
+				return nil, fmt.Errorf("meta type %d out of range", v)
+			}
+			msg.Meta = MetaType(v)
+		case (num == protoFieldOtherClient || num == protoFieldConnectionID) && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			if n >= 0 && !utf8.Valid(v) {
+				return nil, errInvalidUTF8
+			}
+			if num == protoFieldOtherClient {
+				msg.OtherClient = string(v)
+			} else {
+				msg.ConnectionID = string(v)
+			}
+		case num == protoFieldData && typ == protowire.BytesType:
+			msg.Data, n = protowire.ConsumeBytes(b)
+			if len(msg.Data) == 0 {
+				msg.Data = nil
+			}
+		case num >= protoFieldMeta && num <= protoFieldData:
+			return nil, fmt.Errorf("field %d has wire type %d", num, typ)
+		default:
+			// unknown fields are skipped for forward compatibility
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return msg, nil
+}
@@ -1 +1,413 @@
 package messagepassing
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by Server.Serve after the server was closed.
+var ErrServerClosed = errors.New("server closed")
+
+const (
+	errStringAuthFailed   = "authentication failed"
+	errStringNameInUse    = "client name already in use"
+	errStringAuthExpected = "expected authentication message"
+)
+
+// outgoingQueueSize is number of messages queued for a client. A client
+// that lets its queue fill up is disconnected: blocking the sender instead
+// would stall the sender's own read loop, and two clients that stop reading
+// while writing to each other would deadlock.
+const outgoingQueueSize = 128
+
+// Server relays messages between authenticated Clients.
+//
+// Clients are identified by the name they authenticated with. A connection is
+// opened by one client sending MetaConnSyn to another and is established once
+// the other client answers with MetaConnACk; the server then relays data
+// messages of the connection between the two clients until either closes it
+// or drops off.
+type Server struct {
+	translatorMaker TranslatorMaker
+	auth            Authenticator
+	authorizer      Authorizer
+
+	clients     map[string]*serverClient
+	connections map[string]*serverConnection // by ConnectionID
+	listeners   map[net.Listener]struct{}
+	closed      bool
+	lock        sync.Mutex
+}
+
+// serverClient is an authenticated client connected to Server.
+type serverClient struct {
+	name       string
+	conn       io.ReadWriteCloser
+	translator MessageTranslator
+
+	outgoing  chan *Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// serverConnection is a connection between two clients relayed by Server.
+type serverConnection struct {
+	initiator   string // client that sent MetaConnSyn
+	acceptor    string // client the connection was requested from
+	established bool   // whether acceptor has answered with MetaConnACk
+}
+
+// peer returns the other end of the connection as seen from client name, if
+// name is one of its ends.
+func (c *serverConnection) peer(name string) (string, bool) {
+	switch name {
+	case c.initiator:
+		return c.acceptor, true
+	case c.acceptor:
+		return c.initiator, true
+	}
+	return "", false
+}
+
+// NewServer creates a Server that talks to clients in format of tm and
+// authenticates them with auth. If auth is nil, any client is accepted.
+func NewServer(tm TranslatorMaker, auth Authenticator) *Server {
+	return &Server{
+		translatorMaker: tm,
+		auth:            auth,
+		clients:         make(map[string]*serverClient),
+		connections:     make(map[string]*serverConnection),
+		listeners:       make(map[net.Listener]struct{}),
+	}
+}
+
+// SetAuthorizer makes the server consult a about every connection a client
+// opens. Connections that are not allowed are answered with
+// MetaNoSuchConnection, as if the other client was not there.
+//
+// It must be called before the server serves any client.
+func (s *Server) SetAuthorizer(a Authorizer) {
+	s.authorizer = a
+}
+
+// Serve accepts clients from l and serves each in its own goroutine.
+//
+// Serve returns when l fails to accept; after Close it returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.listeners, l)
+		s.lock.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single client connected over conn until it disconnects.
+//
+// The client has to authenticate with its first message. conn is closed when
+// ServeConn returns; a client disconnecting normally is not an error.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) error {
+	defer conn.Close()
+	translator := s.translatorMaker(conn, conn)
+
+	sc, err := s.authenticate(conn, translator)
+	if err != nil {
+		return err
+	}
+	go sc.writeLoop()
+	defer s.removeClient(sc)
+
+	for {
+		msg, err := translator.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.route(sc, msg)
+	}
+}
+
+// authenticate reads authentication message of a client and registers the
+// client if it is accepted.
+func (s *Server) authenticate(conn io.ReadWriteCloser, translator MessageTranslator) (*serverClient, error) {
+	msg, err := translator.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	fail := func(reason string) (*serverClient, error) {
+		_ = translator.WriteMessage(&Message{Meta: MetaauthFailure, OtherClient: msg.OtherClient, Data: []byte(reason)})
+		return nil, errors.New(reason)
+	}
+	if msg.Meta != MetaAuth {
+		return fail(errStringAuthExpected)
+	}
+	name := msg.OtherClient
+	if name == "" {
+		return fail(errStringAuthFailed)
+	}
+	if s.auth != nil {
+		challenge, err := s.auth.Challenge(name)
+		if err != nil {
+			return fail(errStringAuthFailed)
+		}
+		credentials := msg.Data
+		if challenge != nil {
+			err = translator.WriteMessage(&Message{Meta: MetaAuthChallenge, OtherClient: name, Data: challenge})
+			if err != nil {
+				return nil, err
+			}
+			msg, err = translator.ReadMessage()
+			if err != nil {
+				return nil, err
+			}
+			if msg.Meta != MetaAuth || msg.OtherClient != name {
+				return fail(errStringAuthExpected)
+			}
+			credentials = msg.Data
+		}
+		err = s.auth.Verify(name, challenge, credentials)
+		if err != nil {
+			// the client is not told why, not to help guessing
+			fail(errStringAuthFailed)
+			return nil, fmt.Errorf("client %q: %w", name, err)
+		}
+	}
+
+	sc := &serverClient{
+		name:       name,
+		conn:       conn,
+		translator: translator,
+		outgoing:   make(chan *Message, outgoingQueueSize),
+		closed:     make(chan struct{}),
+	}
+	// MetaAuthOk has to be the first message the client receives, so it
+	// is queued before anyone can route messages to the client
+	sc.outgoing <- &Message{Meta: MetaAuthOk, OtherClient: name}
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil, ErrServerClosed
+	}
+	if _, ok := s.clients[name]; ok {
+		s.lock.Unlock()
+		return fail(errStringNameInUse)
+	}
+	s.clients[name] = sc
+	s.lock.Unlock()
+	return sc, nil
+}
+
+// removeClient unregisters a disconnected client, forgets its connections
+// and tells all other clients it is gone.
+func (s *Server) removeClient(sc *serverClient) {
+	sc.close()
+
+	s.lock.Lock()
+	if s.clients[sc.name] == sc {
+		delete(s.clients, sc.name)
+	}
+	for id, conn := range s.connections {
+		if _, ok := conn.peer(sc.name); ok {
+			delete(s.connections, id)
+		}
+	}
+	others := make([]*serverClient, 0, len(s.clients))
+	for _, other := range s.clients {
+		others = append(others, other)
+	}
+	s.lock.Unlock()
+
+	for _, other := range others {
+		other.send(&Message{Meta: MetaClientCLosed, OtherClient: sc.name})
+	}
+}
+
+// route handles a message from client sc: messages of a connection are
+// passed on to the other end of the connection with OtherClient set to sc.
+func (s *Server) route(sc *serverClient, msg *Message) {
+	switch msg.Meta {
+	case MetaNone, MetaWindowUpdate:
+		ok := s.forward(sc, msg, func(conn *serverConnection) bool {
+			return conn.established
+		})
+		if !ok {
+			s.noSuchConnection(sc, msg)
+		}
+	case MetaConnSyn:
+		s.connect(sc, msg)
+	case MetaConnACk, MetaUnknownProto:
+		ok := s.forward(sc, msg, func(conn *serverConnection) bool {
+			if conn.established || sc.name != conn.acceptor {
+				return false
+			}
+			if msg.Meta == MetaConnACk {
+				conn.established = true
+			} else {
+				delete(s.connections, msg.ConnectionID)
+			}
+			return true
+		})
+		if !ok {
+			s.noSuchConnection(sc, msg)
+		}
+	case MetaConnClosed, MetaNoSuchConnection:
+		// the connection is gone on sc's side either way; there is no
+		// point in answering that it does not exist
+		s.forward(sc, msg, func(conn *serverConnection) bool {
+			delete(s.connections, msg.ConnectionID)
+			return true
+		})
+	case MetaAuth, MetaAuthChallenge:
+		sc.send(&Message{Meta: MetaauthFailure, OtherClient: sc.name, Data: []byte(errSTringMultuipleAuths)})
+	case MetaWAT:
+		// the client did not understand something we sent; answering
+		// with MetaWAT again could go on forever
+	default:
+		msg.Meta = MetaWAT
+		msg.Data = nil
+		sc.send(msg)
+	}
+}
+
+// connect registers connection requested by MetaConnSyn from sc and passes
+// the request on to the requested client.
+func (s *Server) connect(sc *serverClient, msg *Message) {
+	s.lock.Lock()
+	to, ok := s.clients[msg.OtherClient]
+	if ok && s.authorizer != nil {
+		ok = s.authorizer.AllowConnection(sc.name, to.name)
+	}
+	if ok && to != sc && s.connections[msg.ConnectionID] == nil {
+		s.connections[msg.ConnectionID] = &serverConnection{initiator: sc.name, acceptor: to.name}
+	} else {
+		ok = false
+	}
+	s.lock.Unlock()
+	if !ok {
+		s.noSuchConnection(sc, msg)
+		return
+	}
+	msg.OtherClient = sc.name
+	to.send(msg)
+}
+
+// forward passes message of connection msg.ConnectionID from sc on to the
+// other end of the connection, with OtherClient set to sc.
+//
+// update is called with .lock held to check that the message is valid for
+// the connection and to update state of the connection. forward returns
+// false if the message could not be passed on.
+func (s *Server) forward(sc *serverClient, msg *Message, update func(*serverConnection) bool) bool {
+	s.lock.Lock()
+	conn, ok := s.connections[msg.ConnectionID]
+	var to *serverClient
+	if ok {
+		var peer string
+		peer, ok = conn.peer(sc.name)
+		to = s.clients[peer]
+		ok = ok && to != nil && update(conn)
+	}
+	s.lock.Unlock()
+	if !ok {
+		return false
+	}
+	msg.OtherClient = sc.name
+	to.send(msg)
+	return true
+}
+
+// noSuchConnection answers msg from sc with MetaNoSuchConnection.
+func (s *Server) noSuchConnection(sc *serverClient, msg *Message) {
+	sc.send(&Message{
+		Meta:         MetaNoSuchConnection,
+		OtherClient:  msg.OtherClient,
+		ConnectionID: msg.ConnectionID,
+	})
+}
+
+// Close stops all listeners passed to Serve and disconnects all clients.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	listeners := s.listeners
+	s.listeners = make(map[net.Listener]struct{})
+	clients := make([]*serverClient, 0, len(s.clients))
+	for _, sc := range s.clients {
+		clients = append(clients, sc)
+	}
+	s.lock.Unlock()
+
+	var err error
+	for l := range listeners {
+		if lerr := l.Close(); err == nil {
+			err = lerr
+		}
+	}
+	for _, sc := range clients {
+		sc.close()
+	}
+	return err
+}
+
+// send queues msg to be written to the client without blocking. msg is
+// dropped if the client is disconnected, and the client is disconnected if
+// its queue is full.
+func (sc *serverClient) send(msg *Message) {
+	select {
+	case sc.outgoing <- msg:
+	case <-sc.closed:
+	default:
+		sc.close()
+	}
+}
+
+// writeLoop writes queued messages to the client until it is closed.
+func (sc *serverClient) writeLoop() {
+	for {
+		select {
+		case msg := <-sc.outgoing:
+			err := sc.translator.WriteMessage(msg)
+			if err != nil {
+				sc.close()
+				return
+			}
+		case <-sc.closed:
+			return
+		}
+	}
+}
+
+// close disconnects the client. It is safe to call more than once.
+func (sc *serverClient) close() {
+	sc.closeOnce.Do(func() {
+		close(sc.closed)
+		sc.conn.Close()
+	})
+}
@@ -0,0 +1,372 @@
+package messagepassing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var translatorMakers = map[string]TranslatorMaker{
+	"gob":      NewGobTranslator,
+	"json":     NewJSOnTranslators,
+	"binary":   NewBinaryTranslator,
+	"protobuf": NewProtobufTranslator,
+}
+
+func newTestServer(t *testing.T, tm TranslatorMaker) *Server {
+	t.Helper()
+	s := NewServer(tm, NewStaticAuthenticator(map[string]string{
+		"alice":   "alice-secret",
+		"bob":     "bob-secret",
+		"mallory": "mallory-secret",
+	}))
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// dial connects a client to s over net.Pipe.
+func dial(s *Server) net.Conn {
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	return client
+}
+
+// startClient connects and authenticates client name and runs it.
+func startClient(t *testing.T, s *Server, tm TranslatorMaker, name string, ch NewConnectionhandler) *Client {
+	t.Helper()
+	if ch == nil {
+		ch = NewConnectionHandler()
+	}
+	c := NewClient(name, dial(s), tm, ch)
+	runClient(t, c, name)
+	return c
+}
+
+// runClient authenticates client name and runs it.
+func runClient(t *testing.T, c *Client, name string) {
+	t.Helper()
+	err := c.Authenticate([]byte(name + "-secret"))
+	if err != nil {
+		t.Fatalf("%s: authenticate: %v", name, err)
+	}
+	go c.Run()
+	t.Cleanup(func() { c.Close() })
+}
+
+// echoHandler accepts "echo" connections and echoes every message back.
+func echoHandler() *MappedConnectionHandler {
+	h := NewConnectionHandler()
+	h.AddMapping("echo", func(conn Connection) {
+		defer conn.Close()
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if conn.WriteMessage(msg) != nil {
+				return
+			}
+		}
+	})
+	return h
+}
+
+// readTimeout reads a message from conn, failing the test if none arrives.
+func readTimeout(t *testing.T, conn Connection) ([]byte, error) {
+	t.Helper()
+	type result struct {
+		msg []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		msg, err := conn.ReadMessage()
+		done <- result{msg, err}
+	}()
+	select {
+	case r := <-done:
+		return r.msg, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out reading from connection")
+		return nil, nil
+	}
+}
+
+func TestServerAuthenticate(t *testing.T) {
+	for name, tm := range translatorMakers {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, tm)
+			startClient(t, s, tm, "alice", nil)
+
+			c := NewClient("bob", dial(s), tm, NewConnectionHandler())
+			defer c.Close()
+			if err := c.Authenticate([]byte("wrong")); err == nil {
+				t.Fatal("wrong password was accepted")
+			}
+
+			c = NewClient("alice", dial(s), tm, NewConnectionHandler())
+			defer c.Close()
+			err := c.Authenticate([]byte("alice-secret"))
+			if err == nil || err.Error() != errStringNameInUse {
+				t.Fatalf("second alice: err = %v; want %q", err, errStringNameInUse)
+			}
+		})
+	}
+}
+
+func TestServerRelay(t *testing.T) {
+	for name, tm := range translatorMakers {
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, tm)
+			alice := startClient(t, s, tm, "alice", nil)
+			startClient(t, s, tm, "bob", echoHandler())
+
+			conn, err := alice.MakeConnection("bob", "echo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conn.OtherClient() != "bob" {
+				t.Fatalf("OtherClient = %q; want bob", conn.OtherClient())
+			}
+			for _, want := range [][]byte{[]byte("hello"), []byte("\x00\xffbinary")} {
+				err = conn.WriteMessage(want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := readTimeout(t, conn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("echo: got %q; want %q", got, want)
+				}
+			}
+
+			conn.Close()
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				s.lock.Lock()
+				n := len(s.connections)
+				s.lock.Unlock()
+				if n == 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("connection is still relayed after close")
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestServerNoSuchConnection(t *testing.T) {
+	s := newTestServer(t, NewGobTranslator)
+	alice := startClient(t, s, NewGobTranslator, "alice", nil)
+	startClient(t, s, NewGobTranslator, "bob", echoHandler())
+
+	if _, err := alice.MakeConnection("carol", "echo"); err == nil {
+		t.Fatal("connected to client that does not exist")
+	}
+	if _, err := alice.MakeConnection("bob", "ftp"); err == nil || err.Error() != errSTringUnknownProtocol {
+		t.Fatalf("unknown protocol: err = %v; want %q", err, errSTringUnknownProtocol)
+	}
+
+	// data for a connection that was never established
+	conn := dial(s)
+	defer conn.Close()
+	tr := NewGobTranslator(conn, conn)
+	for _, msg := range []*Message{
+		{Meta: MetaAuth, OtherClient: "mallory", Data: []byte("mallory-secret")},
+		{Meta: MetaNone, OtherClient: "bob", ConnectionID: "bob:0", Data: []byte("hi")},
+	} {
+		err := tr.WriteMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Meta == MetaAuth {
+			if resp.Meta != MetaAuthOk {
+				t.Fatalf("auth: got meta %d", resp.Meta)
+			}
+			continue
+		}
+		if resp.Meta != MetaNoSuchConnection || resp.ConnectionID != msg.ConnectionID {
+			t.Fatalf("data on unknown connection: got %+v", resp)
+		}
+	}
+}
+
+func TestServerClientClosed(t *testing.T) {
+	s := newTestServer(t, NewGobTranslator)
+	alice := startClient(t, s, NewGobTranslator, "alice", nil)
+
+	accepted := make(chan Connection, 1)
+	h := NewConnectionHandler()
+	h.AddMapping("hold", func(conn Connection) { accepted <- conn })
+	startClient(t, s, NewGobTranslator, "bob", h)
+
+	_, err := alice.MakeConnection("bob", "hold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bobConn Connection
+	select {
+	case bobConn = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not accepted")
+	}
+
+	// alice drops off without closing the connection; the server tells
+	// bob, whose end of the connection is closed
+	alice.Close()
+	if _, err := readTimeout(t, bobConn); err != io.EOF {
+		t.Fatalf("read after peer dropped: err = %v; want EOF", err)
+	}
+}
+
+func TestServerStuckReader(t *testing.T) {
+	s := newTestServer(t, NewGobTranslator)
+
+	// login authenticates raw client name
+	login := func(name string) MessageTranslator {
+		conn := dial(s)
+		t.Cleanup(func() { conn.Close() })
+		tr := NewGobTranslator(conn, conn)
+		err := tr.WriteMessage(&Message{Meta: MetaAuth, OtherClient: name, Data: []byte(name + "-secret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.ReadMessage()
+		if err != nil || resp.Meta != MetaAuthOk {
+			t.Fatalf("%s: auth: %+v, %v", name, resp, err)
+		}
+		return tr
+	}
+	alice, bob := login("alice"), login("bob")
+
+	id := "alice:0"
+	err := alice.WriteMessage(&Message{Meta: MetaConnSyn, OtherClient: "bob", ConnectionID: id, Data: []byte("hold")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := bob.ReadMessage(); err != nil || msg.Meta != MetaConnSyn {
+		t.Fatalf("bob: %+v, %v", msg, err)
+	}
+	err = bob.WriteMessage(&Message{Meta: MetaConnACk, OtherClient: "alice", ConnectionID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := alice.ReadMessage(); err != nil || msg.Meta != MetaConnACk {
+		t.Fatalf("alice: %+v, %v", msg, err)
+	}
+
+	// bob stops reading its socket while alice keeps sending; the server
+	// must drop bob instead of blocking alice
+	go func() {
+		for i := 0; i < 2*outgoingQueueSize; i++ {
+			err := alice.WriteMessage(&Message{OtherClient: "bob", ConnectionID: id, Data: []byte("data")})
+			if err != nil {
+				return
+			}
+		}
+	}()
+	gone := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := alice.ReadMessage()
+			if err != nil {
+				gone <- err
+				return
+			}
+			if msg.Meta == MetaClientCLosed && msg.OtherClient == "bob" {
+				gone <- nil
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-gone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client that does not read was not disconnected")
+	}
+}
+
+// pipeListener is net.Listener handing out server ends of net.Pipe.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestServerServe(t *testing.T) {
+	s := NewServer(NewJSOnTranslators, nil)
+	l := newPipeListener()
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	conn, err := l.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient("alice", conn, NewJSOnTranslators, NewConnectionHandler())
+	if err := c.Authenticate(nil); err != nil {
+		t.Fatal(err)
+	}
+	ran := make(chan error, 1)
+	go func() { ran <- c.Run() }()
+
+	s.Close()
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Serve: err = %v; want ErrServerClosed", err)
+	}
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not disconnected by Close")
+	}
+}
@@ -1,9 +1,14 @@
 package messagepassing
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"io"
+	"net"
+	"sync"
 )
 
 // Translates messages using the built-in encoding/gob module
@@ -48,3 +53,152 @@ func (t *jsonTranslators) ReadMessage() (*Message, error) {
 func (t *jsonTranslators) WriteMessage(m *Message) error {
 	return t.enc.Encode(m)
 }
+
+// DefaultMaxFrameSize is the largest frame NewBinaryTranslator reads or
+// writes.
+const DefaultMaxFrameSize = 16 << 20
+
+// ErrFrameTooLarge is returned by binary translators for frames over their
+// maximum frame size. A stream that returned it can't be read any further.
+var ErrFrameTooLarge = errors.New("message frame too large")
+
+var errMalformedFrame = errors.New("malformed message frame")
+
+// maxPooledBufferSize is capacity over which frame buffers are not returned
+// to frameBufferPool, not to keep memory of a rare huge header around.
+const maxPooledBufferSize = 64 << 10
+
+// frameBufferPool holds scratch buffers for frame headers.
+var frameBufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+func getFrameBuffer() *[]byte {
+	return frameBufferPool.Get().(*[]byte)
+}
+
+func putFrameBuffer(b *[]byte) {
+	if cap(*b) <= maxPooledBufferSize {
+		*b = (*b)[:0]
+		frameBufferPool.Put(b)
+	}
+}
+
+// Translates messages to compact binary frames. A frame is
+//
+//	uvarint   size of the rest of the frame
+//	byte      Meta
+//	uvarint   len(OtherClient), OtherClient
+//	uvarint   len(ConnectionID), ConnectionID
+//	          Data, up to the end of the frame
+//
+// Data is written as is. Reading allocates the frame once and Data refers to
+// its end, without a further copy.
+type binaryTranslator struct {
+	r            *bufio.Reader
+	w            io.Writer
+	maxFrameSize int
+}
+
+// NewBinaryTranslator creates a new MessageTranslator that reads/writes
+// messages as length-prefixed binary frames of at most DefaultMaxFrameSize
+// bytes.
+func NewBinaryTranslator(r io.Reader, w io.Writer) MessageTranslator {
+	return &binaryTranslator{bufio.NewReader(r), w, DefaultMaxFrameSize}
+}
+
+// BinaryTranslatorMaker returns TranslatorMaker for binary translators with
+// frames of at most maxFrameSize bytes.
+func BinaryTranslatorMaker(maxFrameSize int) TranslatorMaker {
+	return func(r io.Reader, w io.Writer) MessageTranslator {
+		return &binaryTranslator{bufio.NewReader(r), w, maxFrameSize}
+	}
+}
+
+// uvarintSize returns number of bytes x takes as uvarint.
+func uvarintSize(x uint64) int {
+	n := 1
+	for ; x >= 0x80; x >>= 7 {
+		n++
+	}
+	return n
+}
+
+func (t *binaryTranslator) WriteMessage(m *Message) error {
+	size := 1 +
+		uvarintSize(uint64(len(m.OtherClient))) + len(m.OtherClient) +
+		uvarintSize(uint64(len(m.ConnectionID))) + len(m.ConnectionID) +
+		len(m.Data)
+	if size > t.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	bp := getFrameBuffer()
+	defer putFrameBuffer(bp)
+	hdr := binary.AppendUvarint(*bp, uint64(size))
+	hdr = append(hdr, byte(m.Meta))
+	hdr = binary.AppendUvarint(hdr, uint64(len(m.OtherClient)))
+	hdr = append(hdr, m.OtherClient...)
+	hdr = binary.AppendUvarint(hdr, uint64(len(m.ConnectionID)))
+	hdr = append(hdr, m.ConnectionID...)
+	*bp = hdr
+
+	if len(m.Data) == 0 {
+		_, err := t.w.Write(hdr)
+		return err
+	}
+	// writev on network connections, two writes otherwise
+	bufs := net.Buffers{hdr, m.Data}
+	_, err := bufs.WriteTo(t.w)
+	return err
+}
+
+func (t *binaryTranslator) ReadMessage() (*Message, error) {
+	size, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(t.maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	if size == 0 {
+		return nil, errMalformedFrame
+	}
+
+	// Data is left in place at the end of the frame
+	frame := make([]byte, size)
+	_, err = io.ReadFull(t.r, frame)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// [start, end) of OtherClient and ConnectionID in the frame
+	var spans [2][2]int
+	off := 1
+	for i := range spans {
+		n, k := binary.Uvarint(frame[off:])
+		if k <= 0 || n > uint64(len(frame)-off-k) {
+			return nil, errMalformedFrame
+		}
+		off += k
+		spans[i] = [2]int{off, off + int(n)}
+		off += int(n)
+	}
+	// both strings come from a single conversion
+	strs := string(frame[1:off])
+	msg := &Message{
+		Meta:         MetaType(frame[0]),
+		OtherClient:  strs[spans[0][0]-1 : spans[0][1]-1],
+		ConnectionID: strs[spans[1][0]-1 : spans[1][1]-1],
+	}
+	if off < len(frame) {
+		msg.Data = frame[off:]
+	}
+	return msg, nil
+}
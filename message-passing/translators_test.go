@@ -0,0 +1,255 @@
+package messagepassing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// TestTranslatorConformance checks that every translator round-trips
+// messages of every MetaType, with and without header fields and data.
+func TestTranslatorConformance(t *testing.T) {
+	var msgs []*Message
+	for meta := MetaNone; meta < numMetaTypes; meta++ {
+		msgs = append(msgs,
+			&Message{Meta: meta},
+			&Message{Meta: meta, OtherClient: "bob", ConnectionID: "alice:0"},
+			&Message{Meta: meta, OtherClient: "ηλ", ConnectionID: "ηλ:ff", Data: []byte("\x00data\xff")},
+		)
+	}
+	for name, tm := range translatorMakers {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tr := tm(&buf, &buf)
+			for _, msg := range msgs {
+				err := tr.WriteMessage(msg)
+				if err != nil {
+					t.Fatalf("write %+v: %v", msg, err)
+				}
+			}
+			for _, want := range msgs {
+				got, err := tr.ReadMessage()
+				if err != nil {
+					t.Fatalf("read %+v: %v", want, err)
+				}
+				if got.Meta != want.Meta || got.OtherClient != want.OtherClient ||
+					got.ConnectionID != want.ConnectionID || !bytes.Equal(got.Data, want.Data) {
+					t.Errorf("round trip: got %+v; want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestProtobufSchema checks that message.proto lists every MetaType.
+func TestProtobufSchema(t *testing.T) {
+	schema, err := os.ReadFile("message.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := regexp.MustCompile(`(?m)^\s*META_\w+ = (\d+);`).FindAllSubmatch(schema, -1)
+	if len(values) != int(numMetaTypes) {
+		t.Fatalf("message.proto has %d meta types; want %d", len(values), numMetaTypes)
+	}
+	for i, v := range values {
+		if n, _ := strconv.Atoi(string(v[1])); n != i {
+			t.Fatalf("meta type #%d in message.proto has value %d", i, n)
+		}
+	}
+}
+
+// TestProtobufEncoding verifies frames against protobuf encoding of Message in
+// message.proto.
+func TestProtobufEncoding(t *testing.T) {
+	frame := []byte{
+		18,      // size
+		0x08, 5, // meta = META_CONN_SYN
+		0x12, 3, 'b', 'o', 'b', // other_client
+		0x1a, 3, 'a', ':', '0', // connection_id
+		0x22, 4, 'e', 'c', 'h', 'o', // data
+	}
+	msg := &Message{Meta: MetaConnSyn, OtherClient: "bob", ConnectionID: "a:0", Data: []byte("echo")}
+
+	var buf bytes.Buffer
+	err := NewProtobufTranslator(nil, &buf).WriteMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), frame) {
+		t.Fatalf("encoding:\nhave % x\nwant % x", buf.Bytes(), frame)
+	}
+
+	// fields in another order and an unknown field, as a newer peer
+	// could send
+	frame = []byte{
+		21,
+		0x22, 4, 'e', 'c', 'h', 'o',
+		0x28, 0x96, 0x01, // field 5 = 150
+		0x1a, 3, 'a', ':', '0',
+		0x12, 3, 'b', 'o', 'b',
+		0x08, 5,
+	}
+	got, err := NewProtobufTranslator(bytes.NewReader(frame), nil).ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Fatalf("decoding: got %+v; want %+v", got, msg)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"truncated", frame[:len(frame)-1]},
+		{"wrong wire type", []byte{2, 0x10, 1}},
+		{"meta out of range", []byte{3, 0x08, 0x80, 0x02}},
+		{"invalid UTF-8", []byte{3, 0x12, 1, 0xff}},
+	} {
+		if _, err := NewProtobufTranslator(bytes.NewReader(tt.frame), nil).ReadMessage(); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+	if err := ProtobufTranslatorMaker(8)(nil, &buf).WriteMessage(msg); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("write over max frame size: err = %v; want ErrFrameTooLarge", err)
+	}
+}
+
+func TestBinaryTranslatorRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewBinaryTranslator(&buf, &buf)
+	msgs := []*Message{
+		{Meta: MetaNone},
+		{Meta: MetaConnSyn, OtherClient: "bob", ConnectionID: "alice:0", Data: []byte("echo")},
+		{Meta: MetaNone, OtherClient: "ηλ", ConnectionID: "alice:1f", Data: bytes.Repeat([]byte{0, 0xff}, 70000)},
+		{Meta: MetaAuthChallenge, OtherClient: string(make([]byte, 300))},
+	}
+	for _, msg := range msgs {
+		err := tr.WriteMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range msgs {
+		got, err := tr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round trip: got %+v; want %+v", got, want)
+		}
+	}
+	if _, err := tr.ReadMessage(); err != io.EOF {
+		t.Fatalf("read past last frame: err = %v; want EOF", err)
+	}
+}
+
+func TestBinaryTranslatorMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	tr := BinaryTranslatorMaker(64)(&buf, &buf)
+	err := tr.WriteMessage(&Message{Data: make([]byte, 64)})
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("write over max frame size: err = %v; want ErrFrameTooLarge", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("frame over max size was partially written")
+	}
+
+	var frame bytes.Buffer
+	err = NewBinaryTranslator(nil, &frame).WriteMessage(&Message{OtherClient: "bob", Data: make([]byte, 64)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = BinaryTranslatorMaker(64)(bytes.NewReader(frame.Bytes()), nil).ReadMessage()
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("read over max frame size: err = %v; want ErrFrameTooLarge", err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"truncated data", frame.Bytes()[:frame.Len()-1], io.ErrUnexpectedEOF},
+		{"truncated size", []byte{0x80}, io.ErrUnexpectedEOF},
+		{"empty frame", []byte{0}, errMalformedFrame},
+		{"string past frame", []byte{3, byte(MetaNone), 5, 'b', 'o'}, errMalformedFrame},
+	} {
+		_, err := NewBinaryTranslator(bytes.NewReader(tt.frame), nil).ReadMessage()
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v; want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// repeatReader returns the same bytes over and over.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+func TestBinaryTranslatorReadAllocs(t *testing.T) {
+	var frame bytes.Buffer
+	msg := &Message{OtherClient: "bob", ConnectionID: "alice:1f", Data: make([]byte, 1<<16)}
+	err := NewBinaryTranslator(nil, &frame).WriteMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := NewBinaryTranslator(&repeatReader{data: frame.Bytes()}, nil)
+	// the frame, both strings and the message
+	allocs := testing.AllocsPerRun(100, func() {
+		_, err := tr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 3 {
+		t.Fatalf("read message: %v allocations;  want 3", allocs)
+	}
+}
+
+// benchmarkTranslator measures writing and reading back a message with data
+// of size bytes.
+func benchmarkTranslator(b *testing.B, tm TranslatorMaker, size int) {
+	var buf bytes.Buffer
+	tr := tm(&buf, &buf)
+	msg := &Message{Meta: MetaNone, OtherClient: "bob", ConnectionID: "alice:1f", Data: bytes.Repeat([]byte{'x'}, size)}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := tr.WriteMessage(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = tr.ReadMessage()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTranslators(b *testing.B) {
+	for _, size := range []struct {
+		name string
+		n    int
+	}{{"64B", 64}, {"4KB", 4 << 10}, {"1MB", 1 << 20}} {
+		for _, name := range []string{"gob", "json", "binary", "protobuf"} {
+			b.Run(name+"/"+size.name, func(b *testing.B) {
+				benchmarkTranslator(b, translatorMakers[name], size.n)
+			})
+		}
+	}
+}